@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultAspectTolerance = 0.05
+	fallbackOrientation    = "other"
+)
+
+var aspectClassNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// aspectClass names a family of aspect ratios. A video whose width/height
+// ratio is within Tolerance of Width:Height belongs to the class.
+type aspectClass struct {
+	Name      string
+	Width     int
+	Height    int
+	Tolerance float64
+}
+
+func (a aspectClass) ratio() float64 {
+	return float64(a.Width) / float64(a.Height)
+}
+
+func defaultAspectClasses() []aspectClass {
+	return []aspectClass{
+		{Name: "landscape", Width: 16, Height: 9, Tolerance: defaultAspectTolerance},
+		{Name: "portrait", Width: 9, Height: 16, Tolerance: defaultAspectTolerance},
+	}
+}
+
+// parseAspectClasses parses a comma-separated list of classes such as
+// "landscape=16:9,portrait=9:16,standard=4:3@0.03,square=1:1".
+// The optional @tolerance defaults to defaultAspectTolerance.
+func parseAspectClasses(s string) ([]aspectClass, error) {
+	classes := []aspectClass{}
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("aspect class %q: expected name=W:H", entry)
+		}
+		name = strings.TrimSpace(name)
+		if !aspectClassNamePattern.MatchString(name) || name == fallbackOrientation {
+			return nil, fmt.Errorf("aspect class %q: invalid name", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("aspect class %q: duplicate name", name)
+		}
+		seen[name] = true
+
+		class := aspectClass{Name: name, Tolerance: defaultAspectTolerance}
+		ratio, tolerance, hasTolerance := strings.Cut(strings.TrimSpace(spec), "@")
+		if hasTolerance {
+			t, err := strconv.ParseFloat(tolerance, 64)
+			if err != nil || t <= 0 {
+				return nil, fmt.Errorf("aspect class %q: invalid tolerance", name)
+			}
+			class.Tolerance = t
+		}
+
+		w, h, ok := strings.Cut(ratio, ":")
+		if !ok {
+			return nil, fmt.Errorf("aspect class %q: expected ratio W:H", name)
+		}
+		var err error
+		class.Width, err = strconv.Atoi(w)
+		if err != nil || class.Width <= 0 {
+			return nil, fmt.Errorf("aspect class %q: invalid width", name)
+		}
+		class.Height, err = strconv.Atoi(h)
+		if err != nil || class.Height <= 0 {
+			return nil, fmt.Errorf("aspect class %q: invalid height", name)
+		}
+
+		classes = append(classes, class)
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("no aspect classes defined")
+	}
+	return classes, nil
+}
+
+// classifyAspect returns the name of the class closest to the given
+// dimensions, or fallbackOrientation if none is within its tolerance.
+func classifyAspect(classes []aspectClass, width, height int) string {
+	if width <= 0 || height <= 0 {
+		return fallbackOrientation
+	}
+	ratio := float64(width) / float64(height)
+
+	best := fallbackOrientation
+	bestDiff := math.Inf(1)
+	for _, class := range classes {
+		diff := math.Abs(ratio - class.ratio())
+		if diff < class.Tolerance && diff < bestDiff {
+			best = class.Name
+			bestDiff = diff
+		}
+	}
+	return best
+}
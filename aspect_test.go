@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAspectClasses(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []aspectClass
+		wantErr bool
+	}{
+		{
+			spec: "landscape=16:9, portrait=9:16,standard=4:3@0.03",
+			want: []aspectClass{
+				{Name: "landscape", Width: 16, Height: 9, Tolerance: defaultAspectTolerance},
+				{Name: "portrait", Width: 9, Height: 16, Tolerance: defaultAspectTolerance},
+				{Name: "standard", Width: 4, Height: 3, Tolerance: 0.03},
+			},
+		},
+		{
+			spec: "square=1:1,",
+			want: []aspectClass{{Name: "square", Width: 1, Height: 1, Tolerance: defaultAspectTolerance}},
+		},
+		{spec: "", wantErr: true},
+		{spec: "landscape", wantErr: true},
+		{spec: "Landscape=16:9", wantErr: true},
+		{spec: "other=16:9", wantErr: true},
+		{spec: "wide=16:9,wide=21:9", wantErr: true},
+		{spec: "wide=16x9", wantErr: true},
+		{spec: "wide=0:9", wantErr: true},
+		{spec: "wide=16:-9", wantErr: true},
+		{spec: "wide=16:9@0", wantErr: true},
+		{spec: "wide=16:9@x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAspectClasses(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAspectClasses(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAspectClasses(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyAspect(t *testing.T) {
+	defaults := defaultAspectClasses()
+	overlapping := []aspectClass{
+		{Name: "standard", Width: 4, Height: 3, Tolerance: 0.2},
+		{Name: "landscape", Width: 16, Height: 9, Tolerance: 0.5},
+	}
+
+	tests := []struct {
+		name          string
+		classes       []aspectClass
+		width, height int
+		want          string
+	}{
+		{"exact 16:9", defaults, 1920, 1080, "landscape"},
+		{"exact 9:16", defaults, 1080, 1920, "portrait"},
+		{"odd encoder dimensions", defaults, 854, 480, "landscape"},
+		// 16:9 is 1.7778, so the default tolerance reaches up to 1.8278.
+		{"just inside the tolerance", defaults, 1827, 1000, "landscape"},
+		{"just outside the tolerance", defaults, 1828, 1000, "other"},
+		{"just inside below", defaults, 1728, 1000, "landscape"},
+		{"just outside below", defaults, 1727, 1000, "other"},
+		{"unmatched ratio", defaults, 1440, 1080, "other"},
+		{"zero width", defaults, 0, 1080, "other"},
+		{"negative height", defaults, 1920, -1, "other"},
+		{"closest class wins", overlapping, 1500, 1000, "standard"},
+		{"only the wider class matches", overlapping, 2000, 1000, "landscape"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyAspect(tt.classes, tt.width, tt.height); got != tt.want {
+				t.Errorf("classifyAspect(%dx%d) = %q, want %q", tt.width, tt.height, got, tt.want)
+			}
+		})
+	}
+}
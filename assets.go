@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -10,3 +18,72 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+// publicThumbnailsOnly wraps the assets file server so the thumbnails
+// rendered under /assets/thumbnails/ are only served for public videos.
+// Viewers of other videos are given the thumbnail endpoint instead, which
+// checks they may watch the video.
+func (cfg *apiConfig) publicThumbnailsOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dir, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/assets/thumbnails/"), "/")
+		thumbnailID, err := uuid.Parse(dir)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "Couldn't find thumbnail", nil)
+			return
+		}
+		visibility, err := cfg.db.GetThumbnailVisibility(thumbnailID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail", err)
+			return
+		}
+		if visibility != database.VisibilityPublic {
+			respondWithError(w, http.StatusNotFound, "Couldn't find thumbnail", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// publicURL returns the URL clients use to reach path, which must start
+// with a /, on this server.
+func (cfg apiConfig) publicURL(path string) string {
+	return cfg.publicBaseURL + path
+}
+
+// objectURL returns the public CloudFront URL of a key in the video store.
+func (cfg apiConfig) objectURL(key string) string {
+	cfDomain := strings.TrimSuffix(cfg.CfDistributionDomain, "/")
+	return fmt.Sprintf("https://%s/%s", cfDomain, key)
+}
+
+// putFile uploads the file at path to the video store.
+func (cfg apiConfig) putFile(ctx context.Context, key, path, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return cfg.videoStore.Put(ctx, key, f, contentType)
+}
+
+// downloadToTemp copies an object from the video store into a new temp
+// file and returns its path. The caller must remove it.
+func (cfg apiConfig) downloadToTemp(ctx context.Context, key, pattern string) (string, error) {
+	body, err := cfg.videoStore.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	f, err := os.CreateTemp(cfg.scratchDir, pattern)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, body); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
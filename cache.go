@@ -1,10 +1,208 @@
 package main
 
-import "net/http"
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
 
-func noCacheMiddleware(next http.Handler) http.Handler {
+// maxETagBodySize is the largest response cacheMiddleware buffers to hash
+// into an ETag. Larger responses are streamed without one.
+const maxETagBodySize = 1 << 20
+
+// cacheRule sets the Cache-Control of responses whose path starts with
+// Prefix and, if Suffix is set, ends with it.
+type cacheRule struct {
+	Prefix       string
+	Suffix       string
+	CacheControl string
+}
+
+func (c cacheRule) matches(path string) bool {
+	return strings.HasPrefix(path, c.Prefix) && strings.HasSuffix(path, c.Suffix)
+}
+
+func defaultCacheRules() []cacheRule {
+	return []cacheRule{
+		// Thumbnails are only served while their video is public, so
+		// caches must check back, by ETag, before each reuse.
+		{Prefix: "/assets/thumbnails/", CacheControl: "private, no-cache"},
+		// Avatars are written under new random names whenever they
+		// change, so a URL's content never does.
+		{Prefix: "/assets/avatars/", CacheControl: "public, max-age=31536000, immutable"},
+		{Prefix: "/assets/", CacheControl: "public, max-age=300"},
+		{Prefix: "/app/", CacheControl: "no-cache"},
+		{Prefix: "/api/", CacheControl: "no-store"},
+		{Prefix: "/admin/", CacheControl: "no-store"},
+	}
+}
+
+// parseCacheRules parses a semicolon-separated list of rules such as
+// "/assets/*.vtt=public, max-age=60;/api/=no-store". A * splits the
+// pattern into a prefix and a suffix. Rules are tried in order and the
+// first match wins.
+func parseCacheRules(s string) ([]cacheRule, error) {
+	rules := []cacheRule{}
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pattern, cacheControl, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("cache rule %q: expected pattern=directives", entry)
+		}
+		pattern = strings.TrimSpace(pattern)
+		if !strings.HasPrefix(pattern, "/") || strings.Count(pattern, "*") > 1 {
+			return nil, fmt.Errorf("cache rule %q: pattern must start with / and contain at most one *", entry)
+		}
+		cacheControl = strings.TrimSpace(cacheControl)
+		if cacheControl == "" {
+			return nil, fmt.Errorf("cache rule %q: missing directives", entry)
+		}
+
+		prefix, suffix, _ := strings.Cut(pattern, "*")
+		rules = append(rules, cacheRule{Prefix: prefix, Suffix: suffix, CacheControl: cacheControl})
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no cache rules given")
+	}
+	return rules, nil
+}
+
+// cacheMiddleware applies the first rule matching each request's path.
+// Its Cache-Control is added to successful responses unless the handler
+// set its own; errors are never cached. Small GET responses without an
+// ETag, other than no-store ones, are buffered and given one from a hash
+// of their body, and requests whose If-None-Match matches it get a 304.
+func cacheMiddleware(rules []cacheRule, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "no-store")
-		next.ServeHTTP(w, r)
+		var rule *cacheRule
+		for i := range rules {
+			if rules[i].matches(r.URL.Path) {
+				rule = &rules[i]
+				break
+			}
+		}
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &cacheWriter{
+			ResponseWriter: w,
+			request:        r,
+			cacheControl:   rule.CacheControl,
+			buffering:      r.Method == http.MethodGet && r.Header.Get("Range") == "" && !strings.Contains(rule.CacheControl, "no-store"),
+		}
+		next.ServeHTTP(cw, r)
+		cw.finish()
 	})
 }
+
+// cacheWriter holds back the response while it may still get an ETag.
+type cacheWriter struct {
+	http.ResponseWriter
+	request      *http.Request
+	cacheControl string
+	buffering    bool
+	status       int
+	wroteHeader  bool
+	body         bytes.Buffer
+}
+
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	h := cw.Header()
+	if h.Get("Cache-Control") == "" {
+		if status >= 400 {
+			h.Set("Cache-Control", "no-store")
+		} else {
+			h.Set("Cache-Control", cw.cacheControl)
+		}
+	}
+	if status != http.StatusOK || h.Get("ETag") != "" {
+		cw.buffering = false
+	}
+	if length, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil && length > maxETagBodySize {
+		cw.buffering = false
+	}
+	if !cw.buffering {
+		cw.flushHeader()
+	}
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.buffering {
+		return cw.ResponseWriter.Write(b)
+	}
+	if cw.body.Len()+len(b) > maxETagBodySize {
+		// Too big to hash: give up on the ETag and stream the rest.
+		cw.buffering = false
+		cw.flushHeader()
+		if _, err := cw.ResponseWriter.Write(cw.body.Bytes()); err != nil {
+			return 0, err
+		}
+		cw.body.Reset()
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.body.Write(b)
+}
+
+func (cw *cacheWriter) flushHeader() {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+}
+
+// finish sends a buffered response, or a 304 if the client already has it.
+func (cw *cacheWriter) finish() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.buffering {
+		return
+	}
+
+	sum := sha256.Sum256(cw.body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	h := cw.Header()
+	h.Set("ETag", etag)
+	if etagMatches(cw.request.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		cw.status = http.StatusNotModified
+		cw.flushHeader()
+		return
+	}
+	cw.flushHeader()
+	cw.ResponseWriter.Write(cw.body.Bytes())
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison the header calls for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
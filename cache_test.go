@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCacheRules(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []cacheRule
+		wantErr bool
+	}{
+		{
+			spec: "/assets/*.vtt=public, max-age=60; /api/=no-store;",
+			want: []cacheRule{
+				{Prefix: "/assets/", Suffix: ".vtt", CacheControl: "public, max-age=60"},
+				{Prefix: "/api/", CacheControl: "no-store"},
+			},
+		},
+		{spec: "", wantErr: true},
+		{spec: "/api/", wantErr: true},
+		{spec: "/api/=", wantErr: true},
+		{spec: "api/=no-store", wantErr: true},
+		{spec: "/a*b*c=no-store", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCacheRules(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCacheRules(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCacheRules(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestCacheMiddleware(t *testing.T) {
+	body := []byte("hello")
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}
+
+	tests := []struct {
+		name             string
+		method           string
+		path             string
+		header           http.Header
+		handler          http.HandlerFunc
+		wantStatus       int
+		wantCacheControl string
+		wantETag         bool
+	}{
+		{
+			name:             "gated thumbnail",
+			path:             "/assets/thumbnails/a/b.png",
+			handler:          ok,
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "private, no-cache",
+			wantETag:         true,
+		},
+		{
+			name:             "avatar",
+			path:             "/assets/avatars/a.png",
+			handler:          ok,
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "public, max-age=31536000, immutable",
+			wantETag:         true,
+		},
+		{
+			name:             "text track under assets",
+			path:             "/assets/a.vtt",
+			handler:          ok,
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "public, max-age=300",
+			wantETag:         true,
+		},
+		{
+			name:             "api",
+			path:             "/api/videos",
+			handler:          ok,
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "no-store",
+		},
+		{
+			name:       "unmatched path",
+			path:       "/other",
+			handler:    ok,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "handler's own policy",
+			path: "/assets/a.png",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "private, no-cache")
+				w.Write(body)
+			},
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "private, no-cache",
+			wantETag:         true,
+		},
+		{
+			name: "handler's own ETag",
+			path: "/assets/a.png",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"mine"`)
+				w.Write(body)
+			},
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "public, max-age=300",
+			wantETag:         true,
+		},
+		{
+			name: "error",
+			path: "/assets/thumbnails/missing.png",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+			wantStatus:       http.StatusNotFound,
+			wantCacheControl: "no-store",
+		},
+		{
+			name:             "range request",
+			path:             "/assets/a.png",
+			header:           http.Header{"Range": {"bytes=0-1"}},
+			handler:          ok,
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "public, max-age=300",
+		},
+		{
+			name:             "head request",
+			method:           http.MethodHead,
+			path:             "/assets/a.png",
+			handler:          ok,
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "public, max-age=300",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			rec := httptest.NewRecorder()
+			cacheMiddleware(defaultCacheRules(), tt.handler).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.wantCacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantCacheControl)
+			}
+			if got := rec.Header().Get("ETag") != ""; got != tt.wantETag {
+				t.Errorf("ETag = %q, want one: %v", rec.Header().Get("ETag"), tt.wantETag)
+			}
+			if tt.wantStatus == http.StatusOK && method == http.MethodGet && !bytes.Equal(rec.Body.Bytes(), body) {
+				t.Errorf("body = %q, want %q", rec.Body.Bytes(), body)
+			}
+		})
+	}
+}
+
+func TestCacheMiddlewareETag(t *testing.T) {
+	handler := cacheMiddleware(defaultCacheRules(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	etag := get("/assets/a.txt?body=one", "").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if again := get("/assets/a.txt?body=one", "").Header().Get("ETag"); again != etag {
+		t.Errorf("ETag of the same body = %q, then %q", etag, again)
+	}
+	if other := get("/assets/a.txt?body=two", "").Header().Get("ETag"); other == etag {
+		t.Errorf("ETag of a different body = %q, want a new one", other)
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{"match", "one", etag, http.StatusNotModified},
+		{"weak match", "one", "W/" + etag, http.StatusNotModified},
+		{"one of several", "one", `"x", ` + etag, http.StatusNotModified},
+		{"any", "one", "*", http.StatusNotModified},
+		{"changed body", "two", etag, http.StatusOK},
+		{"no match", "one", `"x"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get("/assets/a.txt?body="+tt.body, tt.ifNoneMatch)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Header().Get("ETag") == "" {
+				t.Error("response has no ETag")
+			}
+			if rec.Header().Get("Cache-Control") != "public, max-age=300" {
+				t.Errorf("Cache-Control = %q", rec.Header().Get("Cache-Control"))
+			}
+			if tt.wantStatus == http.StatusNotModified {
+				if rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "" {
+					t.Errorf("304 has body %q and Content-Type %q", rec.Body.Bytes(), rec.Header().Get("Content-Type"))
+				}
+			} else if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestCacheMiddlewareLargeBody(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), maxETagBodySize+1)
+	handler := cacheMiddleware(defaultCacheRules(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Written in pieces, so the middleware has buffered some before
+		// it sees the body is too big.
+		w.Write(body[:1024])
+		w.Write(body[1024:])
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/assets/big.bin", nil))
+	if rec.Header().Get("ETag") != "" {
+		t.Errorf("ETag = %q, want none", rec.Header().Get("ETag"))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), body) {
+		t.Errorf("body is %d bytes, want %d", rec.Body.Len(), len(body))
+	}
+}
+
+// TestCacheMiddlewareFileServer checks a thumbnail served by the assets
+// file server can be revalidated with the ETag the middleware gives it.
+func TestCacheMiddlewareFileServer(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "thumbnails", "a")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "thumb.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := cacheMiddleware(defaultCacheRules(), http.StripPrefix("/assets", http.FileServer(http.Dir(root))))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/assets/thumbnails/a/thumb.png", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "png" {
+		t.Fatalf("got %d %q, want 200 \"png\"", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "private, no-cache" {
+		t.Errorf("Cache-Control = %q, want private, no-cache", got)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/assets/thumbnails/a/thumb.png", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("revalidation got %d with %d bytes, want an empty 304", rec.Code, rec.Body.Len())
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxCaptionBytes = 2 << 20
+
+// languagePattern accepts BCP 47 style tags such as "en", "pt-BR" or
+// "zh-Hant".
+var languagePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+func (cfg *apiConfig) handlerCaptionsList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.viewableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	tracks, err := cfg.db.GetCaptionTracks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get caption tracks", err)
+		return
+	}
+
+	for i := range tracks {
+		tracks[i].URL = cfg.mediaURL(r.Context(), video, tracks[i].Key)
+	}
+	respondWithJSON(w, http.StatusOK, tracks)
+}
+
+func (cfg *apiConfig) handlerCaptionsCreate(w http.ResponseWriter, r *http.Request) {
+	cfg.saveCaptionTrack(w, r, false)
+}
+
+func (cfg *apiConfig) handlerCaptionsReplace(w http.ResponseWriter, r *http.Request) {
+	cfg.saveCaptionTrack(w, r, true)
+}
+
+// saveCaptionTrack validates the uploaded SRT or WebVTT file, stores it as
+// WebVTT and records the track. Creating a track that already exists, or
+// replacing one that doesn't, is a client error.
+func (cfg *apiConfig) saveCaptionTrack(w http.ResponseWriter, r *http.Request, replace bool) {
+	video, language, ok := cfg.captionTrackRequest(w, r)
+	if !ok {
+		return
+	}
+
+	existing, err := cfg.db.GetCaptionTrack(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get caption track", err)
+		return
+	}
+	exists := existing.ID != uuid.Nil
+	if exists && !replace {
+		respondWithError(w, http.StatusConflict, "A caption track for this language already exists", nil)
+		return
+	}
+	if !exists && replace {
+		respondWithError(w, http.StatusNotFound, "No caption track for this language", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptionBytes+(1<<20))
+	file, _, err := r.FormFile("captions")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxCaptionBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read captions", err)
+		return
+	}
+	if len(data) > maxCaptionBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Caption file is too large", nil)
+		return
+	}
+
+	vtt, err := captions.ToVTT(data)
+	if err != nil {
+		var parseErr *captions.ParseError
+		if errors.As(err, &parseErr) {
+			respondWithError(w, http.StatusBadRequest, parseErr.Error(), nil)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Captions must be SRT or WebVTT", err)
+		return
+	}
+
+	key, err := cfg.videoKeyTemplate.renderSidecar(video.UserID, video.ID, kindCaptions, language, ".vtt")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build storage key", err)
+		return
+	}
+
+	err = cfg.videoStore.Put(r.Context(), key, bytes.NewReader(vtt), "text/vtt")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store captions", err)
+		return
+	}
+
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label == "" {
+		label = existing.Label
+	}
+	if label == "" {
+		label = language
+	}
+
+	if !exists {
+		track, err := cfg.db.CreateCaptionTrack(database.CreateCaptionTrackParams{
+			VideoID:  video.ID,
+			Language: language,
+			Label:    label,
+			Key:      key,
+			URL:      cfg.objectURL(key),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create caption track", err)
+			return
+		}
+		track.URL = cfg.mediaURL(r.Context(), video, track.Key)
+		respondWithJSON(w, http.StatusCreated, track)
+		return
+	}
+
+	previousKey := existing.Key
+	existing.Label = label
+	existing.Key = key
+	existing.URL = cfg.objectURL(key)
+	err = cfg.db.UpdateCaptionTrack(existing)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update caption track", err)
+		return
+	}
+	if err := cfg.videoStore.Delete(r.Context(), previousKey); err != nil {
+		log.Printf("Couldn't delete replaced captions %s: %v", previousKey, err)
+	}
+
+	track, err := cfg.db.GetCaptionTrack(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get caption track", err)
+		return
+	}
+	track.URL = cfg.mediaURL(r.Context(), video, track.Key)
+	respondWithJSON(w, http.StatusOK, track)
+}
+
+func (cfg *apiConfig) handlerCaptionsDelete(w http.ResponseWriter, r *http.Request) {
+	video, language, ok := cfg.captionTrackRequest(w, r)
+	if !ok {
+		return
+	}
+
+	track, err := cfg.db.GetCaptionTrack(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get caption track", err)
+		return
+	}
+	if track.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No caption track for this language", nil)
+		return
+	}
+
+	err = cfg.db.DeleteCaptionTrack(track.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete caption track", err)
+		return
+	}
+	if err := cfg.videoStore.Delete(r.Context(), track.Key); err != nil {
+		log.Printf("Couldn't delete captions %s: %v", track.Key, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// captionTrackRequest authenticates the caller as the owner of the video in
+// the path and validates the language. It writes the error response itself
+// and returns false if the request can't proceed.
+func (cfg *apiConfig) captionTrackRequest(w http.ResponseWriter, r *http.Request) (database.Video, string, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, "", false
+	}
+	language := r.PathValue("language")
+	if !languagePattern.MatchString(language) {
+		respondWithError(w, http.StatusBadRequest, "Invalid language tag", nil)
+		return database.Video{}, "", false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, "", false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, "", false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, "", false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't change captions on this video", nil)
+		return database.Video{}, "", false
+	}
+	return video, language, true
+}
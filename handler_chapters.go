@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+const (
+	maxChapters           = 500
+	maxChapterTitleLength = 200
+)
+
+func (cfg *apiConfig) handlerChaptersGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.viewableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	chapters, err := cfg.db.GetChapters(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapters", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chapters)
+}
+
+// handlerChaptersUpdate replaces a video's chapters and republishes its
+// WebVTT chapters track. The chapters are embedded into the MP4 the next
+// time the video is processed, by a new upload or POST .../reprocess.
+func (cfg *apiConfig) handlerChaptersUpdate(w http.ResponseWriter, r *http.Request) {
+	type chapter struct {
+		Title string    `json:"title"`
+		Start timestamp `json:"start"`
+	}
+	type parameters struct {
+		Chapters []chapter `json:"chapters"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't change chapters on this video", nil)
+		return
+	}
+	if video.DurationSeconds == nil {
+		respondWithError(w, http.StatusConflict, "Upload the video before adding chapters", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Chapters) > maxChapters {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A video can have at most %d chapters", maxChapters), nil)
+		return
+	}
+
+	chapters := []database.Chapter{}
+	for i, ch := range params.Chapters {
+		title := strings.TrimSpace(ch.Title)
+		start := float64(ch.Start)
+		switch {
+		case title == "":
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chapter %d has no title", i+1), nil)
+			return
+		case len(title) > maxChapterTitleLength || strings.ContainsAny(title, "\r\n") || strings.Contains(title, "-->"):
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chapter %d title must be a single line of at most %d characters", i+1, maxChapterTitleLength), nil)
+			return
+		case start >= *video.DurationSeconds:
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chapter %d starts after the end of the video (%.2fs)", i+1, *video.DurationSeconds), nil)
+			return
+		case i > 0 && start <= chapters[i-1].StartSeconds:
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chapter %d must start after chapter %d", i+1, i), nil)
+			return
+		}
+		chapters = append(chapters, database.Chapter{Title: title, StartSeconds: start})
+	}
+
+	err = cfg.db.ReplaceChapters(video.ID, chapters)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chapters", err)
+		return
+	}
+	saved := video
+	video.Chapters = chapters
+
+	err = cfg.publishChapterTrack(r.Context(), &video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't publish chapters track", err)
+		return
+	}
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		cfg.deleteReplacedObjects(context.WithoutCancel(r.Context()), saved, video)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.deleteReplacedObjects(r.Context(), video, saved)
+
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
+
+// publishChapterTrack uploads a WebVTT chapters track for video.Chapters,
+// or drops the existing one if there are none. The caller saves video and
+// then deletes the track it replaced.
+func (cfg *apiConfig) publishChapterTrack(ctx context.Context, video *database.Video) error {
+	video.ChaptersKey = nil
+	video.ChaptersURL = nil
+
+	chapters := mediaChapters(video)
+	if len(chapters) > 0 {
+		cues := make([]captions.Cue, len(chapters))
+		for i, ch := range chapters {
+			end := *video.DurationSeconds
+			if i+1 < len(chapters) {
+				end = chapters[i+1].Start
+			}
+			cues[i] = captions.Cue{
+				ID:    strconv.Itoa(i + 1),
+				Start: secondsToDuration(ch.Start),
+				End:   secondsToDuration(end),
+				Text:  captions.EscapeText(ch.Title),
+			}
+		}
+
+		key, err := cfg.videoKeyTemplate.renderSidecar(video.UserID, video.ID, kindChapters, "", ".vtt")
+		if err != nil {
+			return fmt.Errorf("couldn't build storage key: %w", err)
+		}
+		err = cfg.videoStore.Put(ctx, key, bytes.NewReader(captions.FormatVTT(cues)), "text/vtt")
+		if err != nil {
+			return err
+		}
+		video.ChaptersKey = &key
+		video.ChaptersURL = cfg.storedURL(key)
+	}
+	return nil
+}
+
+// mediaChapters returns the video's chapters that start before the end of
+// the current upload, which may be shorter than the one they were set on.
+func mediaChapters(video *database.Video) []media.Chapter {
+	if video.DurationSeconds == nil {
+		return nil
+	}
+	chapters := []media.Chapter{}
+	for _, ch := range video.Chapters {
+		if ch.StartSeconds < *video.DurationSeconds {
+			chapters = append(chapters, media.Chapter{Start: ch.StartSeconds, Title: ch.Title})
+		}
+	}
+	return chapters
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
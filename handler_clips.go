@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+const minClipSeconds = 0.5
+
+// handlerClipCreate cuts an excerpt from a video's stored original into a new
+// video owned by the same user and runs it through the normal pipeline.
+func (cfg *apiConfig) handlerClipCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Start timestamp `json:"start"`
+		End   timestamp `json:"end"`
+		Title string    `json:"title"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	start, end := float64(params.Start), float64(params.End)
+	if end-start < minClipSeconds {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Clip end must be at least %.1fs after its start", minClipSeconds), nil)
+		return
+	}
+
+	parent, err := cfg.db.GetVideo(videoID)
+	if err != nil || parent.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if parent.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't clip this video", nil)
+		return
+	}
+	if parent.SourceKey == nil {
+		respondWithError(w, http.StatusConflict, "Video has no stored original to clip", nil)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+		return
+	}
+	opts, err := cfg.processingOptionsForUpload(r.Form, userID)
+	if err != nil {
+		respondWithOptionsError(w, err)
+		return
+	}
+
+	sourcePath, err := cfg.downloadToTemp(r.Context(), *parent.SourceKey, "video-source-*.mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't download original video", err)
+		return
+	}
+	defer os.Remove(sourcePath)
+
+	sourceProbe, err := media.Probe(r.Context(), cfg.media, sourcePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to probe video", err)
+		return
+	}
+	if duration := sourceProbe.Duration(); duration > 0 && end > duration {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Clip end is past the end of the video (%.2fs)", duration), nil)
+		return
+	}
+
+	clipPath := sourcePath + ".clip"
+	copied, err := media.Cut(r.Context(), cfg.media, sourcePath, clipPath, start, end)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't cut clip", err)
+		return
+	}
+	defer os.Remove(clipPath)
+
+	// Clips go through the same checks as uploads, against the current
+	// limits.
+	clipProbe, err := media.Validate(r.Context(), cfg.media, clipPath, cfg.uploadLimits)
+	if err != nil {
+		var validationErr *media.ValidationError
+		if errors.As(err, &validationErr) {
+			respondWithErrorCode(w, http.StatusUnprocessableEntity, validationErr.Code, validationErr.Message, nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to validate clip", err)
+		return
+	}
+	clipFile, err := hashFile(clipPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read clip", err)
+		return
+	}
+
+	title := params.Title
+	if title == "" {
+		title = parent.Title + " (clip)"
+	}
+	clip, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:       title,
+		Description: parent.Description,
+		UserID:      userID,
+		Visibility:  parent.Visibility,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+	clip.ParentVideoID = &parent.ID
+	published := false
+	defer func() {
+		if !published {
+			cfg.discardVideo(context.WithoutCancel(r.Context()), clip)
+		}
+	}()
+
+	version, err := cfg.publishUpload(r.Context(), &clip, clipFile, clipProbe, opts, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to process clip", err)
+		return
+	}
+
+	renditions, err := cfg.db.PublishVideo(clip, &version)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	clip.AudioRenditions = renditions
+	published = true
+	cfg.checkUpload(r.Context(), &clip, clipFile.Path, clipProbe)
+
+	log.Printf("Created clip %s of video %s (%.2fs-%.2fs, stream copy: %t)", clip.ID, parent.ID, start, end, copied)
+	cfg.respondWithVideo(w, r, http.StatusCreated, clip)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// Scopes for DUPLICATE_SCOPE.
+const (
+	duplicateScopeUser     = "user"
+	duplicateScopeInstance = "instance"
+)
+
+// duplicateDurationTolerance is how far apart, as a fraction, two videos'
+// durations may be and still be compared.
+const duplicateDurationTolerance = 0.05
+
+// handlerAdminDuplicates lists every video flagged as a near-duplicate.
+// It requires the ADMIN_API_KEY as an "ApiKey" authorization header.
+func (cfg *apiConfig) handlerAdminDuplicates(w http.ResponseWriter, r *http.Request) {
+	if !cfg.isAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate API key", nil)
+		return
+	}
+
+	videos, err := cfg.db.GetDuplicateVideos()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get duplicate videos", err)
+		return
+	}
+
+	cfg.respondWithVideos(w, r, http.StatusOK, videos)
+}
+
+func (cfg *apiConfig) isAdmin(r *http.Request) bool {
+	if cfg.adminAPIKey == "" {
+		return false
+	}
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(cfg.adminAPIKey)) == 1
+}
+
+// checkDuplicates fingerprints an upload and flags video if it is within
+// the configured distance of another video. Like the quality check, a
+// failure is logged and never fails the upload. The caller saves video.
+func (cfg *apiConfig) checkDuplicates(ctx context.Context, video *database.Video, path string, probe media.ProbeResult) {
+	video.DuplicateOfID = nil
+	video.DuplicateDistance = nil
+
+	duration := probe.Duration()
+	fp, err := media.ComputeFingerprint(ctx, cfg.media, path, duration)
+	if err == nil {
+		err = cfg.db.SaveFingerprint(database.VideoFingerprint{
+			VideoID:         video.ID,
+			UserID:          video.UserID,
+			Fingerprint:     fp.String(),
+			DurationSeconds: duration,
+		})
+	}
+	if err != nil {
+		// The previous upload's fingerprint would otherwise go on being
+		// matched against other uploads.
+		log.Printf("Couldn't fingerprint video %s: %v", video.ID, err)
+		if err := cfg.db.DeleteFingerprint(video.ID); err != nil {
+			log.Printf("Couldn't delete stale fingerprint of video %s: %v", video.ID, err)
+		}
+		return
+	}
+
+	scope := video.UserID
+	if cfg.duplicateScope == duplicateScopeInstance {
+		scope = uuid.Nil
+	}
+	candidates, err := cfg.db.GetFingerprintCandidates(
+		video.ID,
+		scope,
+		duration*(1-duplicateDurationTolerance),
+		duration*(1+duplicateDurationTolerance),
+	)
+	if err != nil {
+		log.Printf("Couldn't get fingerprints to compare with video %s: %v", video.ID, err)
+		return
+	}
+
+	for _, candidate := range candidates {
+		other, err := media.ParseFingerprint(candidate.Fingerprint)
+		if err != nil {
+			continue
+		}
+		distance := fp.Distance(other)
+		if distance > cfg.duplicateMaxDistance {
+			continue
+		}
+		if video.DuplicateDistance == nil || distance < *video.DuplicateDistance {
+			video.DuplicateOfID = &candidate.VideoID
+			video.DuplicateDistance = &distance
+		}
+	}
+	if video.DuplicateOfID != nil {
+		log.Printf("Video %s looks like a duplicate of %s (distance %.2f)", video.ID, *video.DuplicateOfID, *video.DuplicateDistance)
+	}
+}
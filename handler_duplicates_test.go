@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func TestCheckDuplicates(t *testing.T) {
+	probe := media.ProbeResult{
+		Streams: []media.Stream{{CodecType: "video", CodecName: "h264", Width: 1280, Height: 720}},
+		Format:  media.Format{FormatName: "mov,mp4,m4a,3gp,3g2,mj2", Duration: "10.0"},
+	}
+	frames := media.FakeStep{Program: media.ProgramFFmpeg, Result: media.Result{Stdout: bytes.Repeat([]byte{0x80}, 16*9*8)}}
+	failed := media.FakeStep{Program: media.ProgramFFmpeg, Err: &media.Error{Program: media.ProgramFFmpeg, ExitCode: 1}}
+
+	cfg := newTestConfig(t, media.NewFakeRunner(frames, frames, failed))
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "owner@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "First", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Second", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprinted := func(video database.Video) bool {
+		t.Helper()
+		other := first
+		if video.ID == first.ID {
+			other = second
+		}
+		candidates, err := cfg.db.GetFingerprintCandidates(other.ID, user.ID, 0, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range candidates {
+			if c.VideoID == video.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	cfg.checkDuplicates(context.Background(), &first, "first.mp4", probe)
+	if first.DuplicateOfID != nil || !fingerprinted(first) {
+		t.Fatalf("first upload: duplicate of %v, fingerprinted %v", first.DuplicateOfID, fingerprinted(first))
+	}
+
+	cfg.checkDuplicates(context.Background(), &second, "second.mp4", probe)
+	if second.DuplicateOfID == nil || *second.DuplicateOfID != first.ID || !fingerprinted(second) {
+		t.Fatalf("matching upload: duplicate of %v, want %s", second.DuplicateOfID, first.ID)
+	}
+
+	// A new upload that can't be fingerprinted drops the previous
+	// upload's fingerprint along with its verdict.
+	cfg.checkDuplicates(context.Background(), &second, "second-v2.mp4", probe)
+	if second.DuplicateOfID != nil || second.DuplicateDistance != nil {
+		t.Errorf("failed check kept duplicate of %v", second.DuplicateOfID)
+	}
+	if fingerprinted(second) {
+		t.Error("failed check kept the previous upload's fingerprint")
+	}
+	if !fingerprinted(first) {
+		t.Error("failed check removed another video's fingerprint")
+	}
+}
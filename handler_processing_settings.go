@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func (cfg *apiConfig) handlerProcessingSettingsGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	settings, err := cfg.db.GetProcessingSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing settings", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, settings)
+}
+
+func (cfg *apiConfig) handlerProcessingSettingsUpdate(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Decode over the current settings so fields the client leaves out
+	// keep their values.
+	params, err := cfg.db.GetProcessingSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing settings", err)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Watermark.HasImage = params.Watermark.Key != nil
+	if params.LoudnessTargetLUFS != nil {
+		if err := validateLoudnessTarget(*params.LoudnessTargetLUFS); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+	}
+	if err := validateWatermarkSettings(params.Watermark); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	params.AudioRenditions, err = normalizeAudioFormats(params.AudioRenditions)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	err = cfg.db.UpdateProcessingSettings(userID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update processing settings", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, params)
+}
+
+func validateWatermarkSettings(s database.WatermarkSettings) error {
+	if !media.ValidPosition(s.Position) {
+		return errors.New("watermark position must be one of top-left, top-right, bottom-left, bottom-right or center")
+	}
+	if s.Margin < 0 || s.Margin > 500 {
+		return errors.New("watermark margin must be between 0 and 500 pixels")
+	}
+	if s.Scale <= 0 || s.Scale > 1 {
+		return errors.New("watermark scale must be greater than 0 and at most 1")
+	}
+	if s.Opacity <= 0 || s.Opacity > 1 {
+		return errors.New("watermark opacity must be greater than 0 and at most 1")
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+	"github.com/google/uuid"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 500
+	maxProfileLinks      = 5
+	maxLinkLength        = 300
+	// avatarSize is the width and height avatars are cropped and scaled to.
+	avatarSize = 256
+)
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+// reservedHandles would clash with other routes under /api/users.
+var reservedHandles = map[string]bool{"me": true}
+
+// ownProfile is what a user sees of their own profile: the public profile
+// plus their email address.
+type ownProfile struct {
+	database.Profile
+	Email string `json:"email"`
+}
+
+func (cfg *apiConfig) handlerUserMeGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	profile, err := cfg.ownProfile(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+// handlerUserMeUpdate changes the fields of the caller's profile present in
+// the request; the rest keep their values. An empty handle removes it.
+func (cfg *apiConfig) handlerUserMeUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Handle      *string   `json:"handle"`
+		DisplayName *string   `json:"display_name"`
+		Bio         *string   `json:"bio"`
+		Links       *[]string `json:"links"`
+	}
+
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	profile, err := cfg.db.GetProfile(userID)
+	if err != nil || profile.UserID == uuid.Nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	if params.Handle != nil {
+		handle := strings.ToLower(strings.TrimSpace(*params.Handle))
+		if handle == "" {
+			profile.Handle = nil
+		} else if err := validateHandle(handle); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		} else {
+			profile.Handle = &handle
+		}
+	}
+	if params.DisplayName != nil {
+		name := strings.TrimSpace(*params.DisplayName)
+		if err := validateDisplayName(name); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		profile.DisplayName = name
+	}
+	if params.Bio != nil {
+		bio := strings.TrimSpace(*params.Bio)
+		if utf8.RuneCountInString(bio) > maxBioLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("bio must be at most %d characters", maxBioLength), nil)
+			return
+		}
+		profile.Bio = bio
+	}
+	if params.Links != nil {
+		links, err := normalizeLinks(*params.Links)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		profile.Links = links
+	}
+
+	err = cfg.db.UpdateProfile(profile)
+	if errors.Is(err, database.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, "Handle is already taken", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update profile", err)
+		return
+	}
+
+	own, err := cfg.ownProfile(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, own)
+}
+
+// handlerUserProfileGet returns anyone's public profile by handle. It needs
+// no authentication.
+func (cfg *apiConfig) handlerUserProfileGet(w http.ResponseWriter, r *http.Request) {
+	handle := strings.ToLower(r.PathValue("handle"))
+	if !handlePattern.MatchString(handle) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return
+	}
+
+	profile, err := cfg.db.GetProfileByHandle(handle)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	if profile.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+// handlerAvatarUpload sets the caller's avatar. The image goes through the
+// same validation as thumbnails, then is cropped square around its most
+// detailed region and scaled to avatarSize.
+func (cfg *apiConfig) handlerAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, thumbnailLimits.MaxBytes+(1<<20))
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		respondWithUploadError(w, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	img, err := cfg.decodeImage(r.Context(), file)
+	if err != nil {
+		var imageErr *imaging.Error
+		if errors.As(err, &imageErr) {
+			respondWithErrorCode(w, http.StatusUnprocessableEntity, imageErr.Code, imageErr.Message, nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read avatar", err)
+		return
+	}
+	img = imaging.Resize(imaging.CropToAspect(img, 1, 1, nil), avatarSize)
+
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random bytes", err)
+		return
+	}
+	path := fmt.Sprintf("avatars/%s/%s.jpg", userID, hex.EncodeToString(randomBytes))
+	fullPath := filepath.Join(cfg.assetsRoot, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store avatar", err)
+		return
+	}
+	out, err := os.Create(fullPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store avatar", err)
+		return
+	}
+	err = jpeg.Encode(out, img, &jpeg.Options{Quality: 85})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fullPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store avatar", err)
+		return
+	}
+
+	profile, err := cfg.db.GetProfile(userID)
+	if err != nil || profile.UserID == uuid.Nil {
+		os.Remove(fullPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	previousPath := profile.AvatarPath
+	avatarURL := cfg.assetURL(path)
+	profile.AvatarPath = &path
+	profile.AvatarURL = &avatarURL
+	err = cfg.db.UpdateProfile(profile)
+	if err != nil {
+		os.Remove(fullPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save avatar", err)
+		return
+	}
+	cfg.deleteAvatarFile(previousPath)
+
+	own, err := cfg.ownProfile(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, own)
+}
+
+func (cfg *apiConfig) handlerAvatarDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	profile, err := cfg.db.GetProfile(userID)
+	if err != nil || profile.UserID == uuid.Nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	previousPath := profile.AvatarPath
+	profile.AvatarPath = nil
+	profile.AvatarURL = nil
+	err = cfg.db.UpdateProfile(profile)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove avatar", err)
+		return
+	}
+	cfg.deleteAvatarFile(previousPath)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticatedUser returns the caller's user ID from their JWT,
+// responding with an error and returning false if there isn't a valid one.
+func (cfg *apiConfig) authenticatedUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+func (cfg *apiConfig) ownProfile(userID uuid.UUID) (ownProfile, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return ownProfile{}, err
+	}
+	if user == nil {
+		return ownProfile{}, errors.New("user not found")
+	}
+	profile, err := cfg.db.GetProfile(userID)
+	if err != nil {
+		return ownProfile{}, err
+	}
+	return ownProfile{Profile: profile, Email: user.Email}, nil
+}
+
+func (cfg *apiConfig) deleteAvatarFile(path *string) {
+	if path == nil {
+		return
+	}
+	if err := os.Remove(filepath.Join(cfg.assetsRoot, filepath.FromSlash(*path))); err != nil && !os.IsNotExist(err) {
+		log.Printf("Couldn't delete avatar %s: %v", *path, err)
+	}
+}
+
+func validateHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return errors.New("handle must be 3 to 30 lowercase letters, digits or underscores")
+	}
+	if reservedHandles[handle] {
+		return errors.New("handle is reserved")
+	}
+	return nil
+}
+
+func validateDisplayName(name string) error {
+	if utf8.RuneCountInString(name) > maxDisplayNameLength {
+		return fmt.Errorf("display name must be at most %d characters", maxDisplayNameLength)
+	}
+	for _, c := range name {
+		if unicode.IsControl(c) {
+			return errors.New("display name must be a single line of text")
+		}
+	}
+	return nil
+}
+
+// normalizeLinks checks each link is an absolute http or https URL,
+// dropping blanks and repeats.
+func normalizeLinks(links []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, link := range links {
+		link = strings.TrimSpace(link)
+		if link == "" || seen[link] {
+			continue
+		}
+		if len(link) > maxLinkLength {
+			return nil, fmt.Errorf("links must be at most %d characters", maxLinkLength)
+		}
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(link, "\r\n") {
+			return nil, fmt.Errorf("link %q must be an http or https URL", link)
+		}
+		seen[link] = true
+		normalized = append(normalized, link)
+	}
+	if len(normalized) > maxProfileLinks {
+		return nil, fmt.Errorf("at most %d links are allowed", maxProfileLinks)
+	}
+	return normalized, nil
+}
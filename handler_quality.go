@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func (cfg *apiConfig) handlerQualityReportGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r, "You can't view this video's quality report")
+	if !ok {
+		return
+	}
+
+	report, err := cfg.db.GetQualityReport(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get quality report", err)
+		return
+	}
+	if report.Verdict == "" {
+		respondWithError(w, http.StatusNotFound, "Video has no quality report yet", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+// runQualityCheck analyzes an upload for black, silent and frozen
+// stretches and saves the report. A failed check only loses the report;
+// it never fails the upload. The caller saves video.
+func (cfg *apiConfig) runQualityCheck(ctx context.Context, video *database.Video, path string, probe media.ProbeResult) {
+	video.QCVerdict = nil
+
+	report, err := media.AnalyzeQuality(ctx, cfg.media, path, probe)
+	if err == nil {
+		var details []byte
+		details, err = json.Marshal(report)
+		if err == nil {
+			err = cfg.db.SaveQualityReport(database.QualityReport{
+				VideoID:      video.ID,
+				SourceSHA256: video.SourceSHA256,
+				Verdict:      report.Verdict,
+				Details:      details,
+			})
+		}
+	}
+	if err != nil {
+		log.Printf("Couldn't check quality of video %s: %v", video.ID, err)
+		if err := cfg.db.DeleteQualityReport(video.ID); err != nil {
+			log.Printf("Couldn't delete stale quality report of video %s: %v", video.ID, err)
+		}
+		return
+	}
+	video.QCVerdict = &report.Verdict
+}
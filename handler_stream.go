@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// handlerVideoStream serves one of a video's stored files to anyone allowed
+// to watch it, straight from the video store. Range, If-Range, ETag and
+// Last-Modified are handled by http.ServeContent, so browsers can seek.
+// Without a key query parameter it serves the published video; otherwise
+// the key must be one of the video's chapters, caption or audio files, or,
+// for the owner, one of its versions.
+func (cfg *apiConfig) handlerVideoStream(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.viewableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		if video.VideoKey == nil {
+			respondWithError(w, http.StatusNotFound, "Video has no stored file", nil)
+			return
+		}
+		key = *video.VideoKey
+	} else {
+		ok, err := cfg.videoHasKey(r, video, key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check file", err)
+			return
+		}
+		if !ok {
+			respondWithError(w, http.StatusNotFound, "Couldn't find file", nil)
+			return
+		}
+	}
+
+	object, err := cfg.videoStore.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find file", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open file", err)
+		return
+	}
+	defer object.Close()
+
+	serveObject(w, r, path.Base(key), mediaCacheControl(video), object.Info(), object)
+}
+
+// handlerVideoThumbnail serves a video's thumbnail to anyone allowed to
+// watch it. The width and format query parameters pick a rendition; by
+// default the largest JPEG is served.
+func (cfg *apiConfig) handlerVideoThumbnail(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.viewableVideo(w, r)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = media.ImageFormatJPEG
+	}
+	width := 0
+	if s := r.URL.Query().Get("width"); s != "" {
+		var err error
+		width, err = strconv.Atoi(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid width", err)
+			return
+		}
+	}
+	var chosen *database.Thumbnail
+	for i, t := range video.Thumbnails {
+		if t.Format != format || (width != 0 && t.Width != width) {
+			continue
+		}
+		if chosen == nil || t.Width > chosen.Width {
+			chosen = &video.Thumbnails[i]
+		}
+	}
+	if chosen == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find thumbnail", nil)
+		return
+	}
+
+	name := strconv.Itoa(chosen.Width) + "." + chosen.Format
+	f, err := os.Open(filepath.Join(cfg.assetsRoot, "thumbnails", chosen.ThumbnailID.String(), name))
+	if errors.Is(err, os.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find thumbnail", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open thumbnail", err)
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open thumbnail", err)
+		return
+	}
+
+	serveObject(w, r, name, mediaCacheControl(video), storage.FileInfo(stat, chosen.MediaType), f)
+}
+
+// videoHasKey reports whether key is one of the files published for video
+// that its viewer may fetch.
+func (cfg *apiConfig) videoHasKey(r *http.Request, video database.Video, key string) (bool, error) {
+	if video.ChaptersKey != nil && *video.ChaptersKey == key {
+		return true, nil
+	}
+	if slices.ContainsFunc(video.Captions, func(t database.CaptionTrack) bool { return t.Key == key }) {
+		return true, nil
+	}
+	if slices.ContainsFunc(video.AudioRenditions, func(a database.AudioRendition) bool { return a.Key == key }) {
+		return true, nil
+	}
+
+	viewerID, err := cfg.optionalViewer(r)
+	if err != nil || viewerID != video.UserID {
+		return false, nil
+	}
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(versions, func(v database.VideoVersion) bool { return v.VideoKey == key }), nil
+}
+
+// mediaCacheControl lets browsers keep a video's media but revalidate it
+// on every use, since the stream and thumbnail URLs stay the same when a
+// new version is published. Only public videos may be kept by shared
+// caches.
+func mediaCacheControl(video database.Video) string {
+	if video.Visibility == database.VisibilityPublic {
+		return "public, no-cache"
+	}
+	return "private, no-cache"
+}
+
+// serveObject writes content with the validators from info and lets
+// http.ServeContent answer conditional and Range requests. Requests for
+// several ranges are refused, as each would cost an S3 object a GET of
+// its own; players only ever ask for one.
+func serveObject(w http.ResponseWriter, r *http.Request, name, cacheControl string, info storage.ObjectInfo, content io.ReadSeeker) {
+	if strings.Contains(r.Header.Get("Range"), ",") {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Only one range may be requested", nil)
+		return
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	contentType := info.ContentType
+	if contentType == "" || strings.HasPrefix(contentType, "binary/") || contentType == "application/octet-stream" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if info.ETag != "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+	http.ServeContent(w, r, name, info.ModTime, content)
+}
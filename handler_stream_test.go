@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// newStreamVideo creates a video with the given visibility whose published
+// file, stored under key, holds data.
+func newStreamVideo(t *testing.T, cfg *apiConfig, visibility, key string, data []byte) (database.Video, storage.ObjectInfo) {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "owner@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Test video", UserID: user.ID, Visibility: visibility})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.videoStore.Put(context.Background(), key, bytes.NewReader(data), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	video.VideoKey = &key
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	object, err := cfg.videoStore.Open(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	return video, object.Info()
+}
+
+func streamRequest(cfg *apiConfig, video database.Video, query string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID.String()+"/stream"+query, nil)
+	req.SetPathValue("videoID", video.ID.String())
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerVideoStream(rec, req)
+	return rec
+}
+
+func TestHandlerVideoStream(t *testing.T) {
+	cfg := newTestConfig(t, media.NewFakeRunner())
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	video, info := newStreamVideo(t, cfg, database.VisibilityPublic, "landscape/video.mp4", data)
+	lastModified := info.ModTime.UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name             string
+		header           http.Header
+		wantStatus       int
+		wantBody         []byte
+		wantContentRange string
+	}{
+		{
+			name:       "whole file",
+			wantStatus: http.StatusOK,
+			wantBody:   data,
+		},
+		{
+			name:             "range",
+			header:           http.Header{"Range": {"bytes=100-199"}},
+			wantStatus:       http.StatusPartialContent,
+			wantBody:         data[100:200],
+			wantContentRange: "bytes 100-199/1000",
+		},
+		{
+			name:             "open-ended range",
+			header:           http.Header{"Range": {"bytes=900-"}},
+			wantStatus:       http.StatusPartialContent,
+			wantBody:         data[900:],
+			wantContentRange: "bytes 900-999/1000",
+		},
+		{
+			name:             "suffix range",
+			header:           http.Header{"Range": {"bytes=-10"}},
+			wantStatus:       http.StatusPartialContent,
+			wantBody:         data[990:],
+			wantContentRange: "bytes 990-999/1000",
+		},
+		{
+			name:             "range past the end",
+			header:           http.Header{"Range": {"bytes=1000-"}},
+			wantStatus:       http.StatusRequestedRangeNotSatisfiable,
+			wantContentRange: "bytes */1000",
+		},
+		{
+			name:             "several ranges",
+			header:           http.Header{"Range": {"bytes=0-9,20-29"}},
+			wantStatus:       http.StatusRequestedRangeNotSatisfiable,
+			wantContentRange: "bytes */1000",
+		},
+		{
+			name:             "If-Range with the current ETag",
+			header:           http.Header{"Range": {"bytes=0-9"}, "If-Range": {info.ETag}},
+			wantStatus:       http.StatusPartialContent,
+			wantBody:         data[:10],
+			wantContentRange: "bytes 0-9/1000",
+		},
+		{
+			name:             "If-Range with the current date",
+			header:           http.Header{"Range": {"bytes=0-9"}, "If-Range": {lastModified}},
+			wantStatus:       http.StatusPartialContent,
+			wantBody:         data[:10],
+			wantContentRange: "bytes 0-9/1000",
+		},
+		{
+			name:       "If-Range with a stale ETag",
+			header:     http.Header{"Range": {"bytes=0-9"}, "If-Range": {`"stale"`}},
+			wantStatus: http.StatusOK,
+			wantBody:   data,
+		},
+		{
+			name:       "If-None-Match",
+			header:     http.Header{"If-None-Match": {info.ETag}},
+			wantStatus: http.StatusNotModified,
+			wantBody:   []byte{},
+		},
+		{
+			name:       "If-None-Match with a stale ETag",
+			header:     http.Header{"If-None-Match": {`"stale"`}},
+			wantStatus: http.StatusOK,
+			wantBody:   data,
+		},
+		{
+			name:       "If-Modified-Since",
+			header:     http.Header{"If-Modified-Since": {lastModified}},
+			wantStatus: http.StatusNotModified,
+			wantBody:   []byte{},
+		},
+		{
+			name:       "If-Modified-Since before the upload",
+			header:     http.Header{"If-Modified-Since": {info.ModTime.Add(-time.Hour).UTC().Format(http.TimeFormat)}},
+			wantStatus: http.StatusOK,
+			wantBody:   data,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := streamRequest(cfg, video, "", tt.header)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.wantContentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantContentRange)
+			}
+			if tt.wantBody != nil && !bytes.Equal(rec.Body.Bytes(), tt.wantBody) {
+				t.Errorf("body is %d bytes, want %d", rec.Body.Len(), len(tt.wantBody))
+			}
+			if rec.Code == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if got := rec.Header().Get("ETag"); got != info.ETag {
+				t.Errorf("ETag = %q, want %q", got, info.ETag)
+			}
+			if rec.Code == http.StatusNotModified {
+				return
+			}
+			want := map[string]string{
+				"Accept-Ranges": "bytes",
+				"Cache-Control": "public, no-cache",
+				"Content-Type":  "video/mp4",
+				"Last-Modified": lastModified,
+			}
+			for name, value := range want {
+				if got := rec.Header().Get(name); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerVideoStreamAccess(t *testing.T) {
+	cfg := newTestConfig(t, media.NewFakeRunner())
+	video, _ := newStreamVideo(t, cfg, database.VisibilityPrivate, "portrait/video.mp4", []byte("video"))
+
+	chaptersKey := "chapters/track.vtt"
+	if err := cfg.videoStore.Put(context.Background(), chaptersKey, bytes.NewReader([]byte("WEBVTT\n")), "text/vtt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.videoStore.Put(context.Background(), "other/video.mp4", bytes.NewReader([]byte("other")), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	video.ChaptersKey = &chaptersKey
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.MakeJWT(video.UserID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := http.Header{"Authorization": {"Bearer " + token}}
+
+	tests := []struct {
+		name       string
+		query      string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "owner", header: owner, wantStatus: http.StatusOK, wantBody: "video"},
+		{name: "anonymous viewer of a private video", wantStatus: http.StatusNotFound},
+		{name: "chapters track", query: "?key=" + chaptersKey, header: owner, wantStatus: http.StatusOK, wantBody: "WEBVTT\n"},
+		{name: "another video's file", query: "?key=other/video.mp4", header: owner, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := streamRequest(cfg, video, tt.query, tt.header)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+			if rec.Code == http.StatusOK && rec.Header().Get("Cache-Control") != "private, no-cache" {
+				t.Errorf("Cache-Control = %q, want private, no-cache", rec.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
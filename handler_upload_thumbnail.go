@@ -1,16 +1,24 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
-	"mime"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
 	"github.com/google/uuid"
 )
 
@@ -34,73 +42,435 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
-
-	// TODO: implement the upload here
+	r.Body = http.MaxBytesReader(w, r.Body, thumbnailLimits.MaxBytes+(1<<20))
 	const maxMemory = 10 << 20
-	r.ParseMultipartForm(maxMemory)
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		respondWithUploadError(w, "Unable to parse multipart form", err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
 
-	file, fileHeader, err := r.FormFile("thumbnail")
+	file, _, err := r.FormFile("thumbnail")
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
 	defer file.Close()
 
-	rawContentType := fileHeader.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(rawContentType)
+	focal, err := parseFocalPoint(r.FormValue("focal_x"), r.FormValue("focal_y"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Only JPEG and PNG thumbnails are supported", nil)
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video from db", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized", nil)
 		return
 	}
 
-	exts, err := mime.ExtensionsByType(mediaType)
-	if err != nil || len(exts) == 0 {
-		respondWithError(w, http.StatusBadRequest, "Unsupported Content-Type", err)
+	err = cfg.saveThumbnail(r.Context(), &video, file, focal)
+	if err != nil {
+		var imageErr *imaging.Error
+		if errors.As(err, &imageErr) {
+			respondWithErrorCode(w, http.StatusUnprocessableEntity, imageErr.Code, imageErr.Message, nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
 		return
 	}
-	ext := exts[0]
 
-	randomBytes := make([]byte, 32)
-	_, err = rand.Read(randomBytes)
+	err = cfg.db.UpdateVideo(video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random bytes", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
-	randomFilename := base64.RawURLEncoding.EncodeToString(randomBytes)
-	filename := randomFilename + ext
-	imageFilePath := filepath.Join(cfg.assetsRoot, filename)
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
+
+// handlerThumbnailFromFrame sets a video's thumbnail to the frame shown at
+// a given time in its current upload.
+func (cfg *apiConfig) handlerThumbnailFromFrame(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Timestamp *timestamp `json:"timestamp"`
+	}
 
-	outFile, err := os.Create(imageFilePath)
+	video, ok := cfg.ownedVideo(w, r, "You can't change this video's thumbnail")
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to create file", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
-	defer outFile.Close()
+	if params.Timestamp == nil {
+		respondWithError(w, http.StatusBadRequest, "timestamp is required", nil)
+		return
+	}
+	at := float64(*params.Timestamp)
 
-	_, err = io.Copy(outFile, file)
+	if video.VideoKey == nil {
+		respondWithError(w, http.StatusConflict, "Video has no stored file to take a frame from", nil)
+		return
+	}
+	if video.DurationSeconds != nil && at >= *video.DurationSeconds {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("timestamp is past the end of the video (%.2fs)", *video.DurationSeconds), nil)
+		return
+	}
+
+	// Take the frame from the original upload where there is one, as the
+	// published file may carry a watermark.
+	key := *video.VideoKey
+	if video.SourceKey != nil {
+		key = *video.SourceKey
+	}
+	videoPath, err := cfg.downloadToTemp(r.Context(), key, "video-frame-*.mp4")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to write file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't download video", err)
 		return
 	}
+	defer os.Remove(videoPath)
 
-	video, err := cfg.db.GetVideo(videoID)
+	framePath := videoPath + ".jpg"
+	err = media.ExtractFrame(r.Context(), cfg.media, videoPath, framePath, at)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get video from db", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't extract frame", err)
+		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized", nil)
+	defer os.Remove(framePath)
+
+	frame, err := os.Open(framePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read frame", err)
+		return
 	}
+	defer frame.Close()
 
-	newVideoThumbnailUrl := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, filename)
-	video.ThumbnailURL = &newVideoThumbnailUrl
+	err = cfg.saveThumbnail(r.Context(), &video, frame, nil)
+	if err != nil {
+		var imageErr *imaging.Error
+		if errors.As(err, &imageErr) {
+			respondWithErrorCode(w, http.StatusUnprocessableEntity, imageErr.Code, imageErr.Message, nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
+		return
+	}
 
 	err = cfg.db.UpdateVideo(video)
-	respondWithJSON(w, http.StatusOK, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
+
+// handlerThumbnailCrop crops the video's thumbnail again from the image
+// originally uploaded, around a new focal point or, without one, around
+// the most detailed region.
+func (cfg *apiConfig) handlerThumbnailCrop(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		FocalPoint *imaging.FocalPoint `json:"focal_point"`
+	}
+
+	video, ok := cfg.ownedVideo(w, r, "You can't change this video's thumbnail")
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.FocalPoint != nil {
+		if err := params.FocalPoint.Validate(); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	if video.ThumbnailSourceKey == nil {
+		respondWithError(w, http.StatusConflict, "Video has no stored thumbnail to crop", nil)
+		return
+	}
+	img, err := cfg.loadThumbnailSource(r.Context(), *video.ThumbnailSourceKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load original thumbnail", err)
+		return
+	}
+
+	err = cfg.publishThumbnail(r.Context(), &video, img, params.FocalPoint)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't crop thumbnail", err)
+		return
+	}
+
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
+
+// parseFocalPoint reads a focal point from a pair of form values. Both
+// must be given, or neither.
+func parseFocalPoint(x, y string) (*imaging.FocalPoint, error) {
+	if x == "" && y == "" {
+		return nil, nil
+	}
+	if x == "" || y == "" {
+		return nil, errors.New("focal_x and focal_y must be given together")
+	}
+	fx, errX := strconv.ParseFloat(x, 64)
+	fy, errY := strconv.ParseFloat(y, 64)
+	if errX != nil || errY != nil {
+		return nil, errors.New("focal_x and focal_y must be numbers")
+	}
+	focal := &imaging.FocalPoint{X: fx, Y: fy}
+	if err := focal.Validate(); err != nil {
+		return nil, err
+	}
+	return focal, nil
+}
+
+// thumbnailWidths are the sizes every thumbnail is rendered at, in both
+// WebP and JPEG, so clients can pick one with srcset.
+var thumbnailWidths = []int{320, 640, 1280}
+
+// Thumbnails are cropped to this aspect ratio so players don't letterbox
+// them.
+const (
+	thumbnailAspectWidth  = 16
+	thumbnailAspectHeight = 9
+)
+
+// thumbnailLimits bound the images accepted as thumbnails and avatars.
+var thumbnailLimits = imaging.Limits{
+	MaxBytes:  10 << 20,
+	MaxWidth:  8192,
+	MaxHeight: 8192,
+	MaxPixels: 40_000_000,
+}
+
+// decodeImage reads an uploaded image within thumbnailLimits. The image
+// is identified by its content, not its declared type, and decoded in
+// full; callers only ever re-encode its pixels, so no EXIF or XMP metadata
+// is published. Rejected images return an *imaging.Error.
+func (cfg *apiConfig) decodeImage(ctx context.Context, src io.Reader) (image.Image, error) {
+	data, mediaType, err := imaging.Read(src, thumbnailLimits)
+	if err != nil {
+		return nil, err
+	}
+	if !imaging.Native(mediaType) {
+		data, err = cfg.convertImage(ctx, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return imaging.DecodeBytes(data, thumbnailLimits)
+}
+
+// saveThumbnail makes an uploaded image the video's thumbnail. The image,
+// decoded by decodeImage, is stored uncropped, replacing the previous one,
+// so it can be cropped again later, and then published by
+// publishThumbnail. The caller is responsible for saving video.
+func (cfg *apiConfig) saveThumbnail(ctx context.Context, video *database.Video, src io.Reader, focal *imaging.FocalPoint) error {
+	img, err := cfg.decodeImage(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	var original bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&original, img); err != nil {
+		return err
+	}
+	key, err := cfg.videoKeyTemplate.renderSidecar(video.UserID, video.ID, kindThumbnail, "", ".png")
+	if err != nil {
+		return fmt.Errorf("couldn't build storage key: %w", err)
+	}
+	err = cfg.videoStore.Put(ctx, key, bytes.NewReader(original.Bytes()), "image/png")
+	if err != nil {
+		return err
+	}
+
+	err = cfg.publishThumbnail(ctx, video, img, focal)
+	if err != nil {
+		cfg.videoStore.Delete(ctx, key)
+		return err
+	}
+
+	previousKey := video.ThumbnailSourceKey
+	video.ThumbnailSourceKey = &key
+	if previousKey != nil {
+		if err := cfg.videoStore.Delete(ctx, *previousKey); err != nil {
+			log.Printf("Couldn't delete previous thumbnail original %s: %v", *previousKey, err)
+		}
+	}
+	return nil
+}
+
+// loadThumbnailSource decodes an uncropped thumbnail stored by
+// saveThumbnail.
+func (cfg *apiConfig) loadThumbnailSource(ctx context.Context, key string) (image.Image, error) {
+	body, err := cfg.videoStore.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, _, err := imaging.Read(body, thumbnailLimits)
+	if err != nil {
+		return nil, err
+	}
+	return imaging.DecodeBytes(data, thumbnailLimits)
+}
+
+// publishThumbnail crops img to the thumbnail aspect ratio, around focal
+// if given, and renders it in each of thumbnailWidths, along with BlurHash
+// and LQIP placeholders. video is pointed at the new renditions and the
+// previous ones are removed. thumbnail_url is kept pointing at the largest
+// JPEG for older clients.
+func (cfg *apiConfig) publishThumbnail(ctx context.Context, video *database.Video, img image.Image, focal *imaging.FocalPoint) error {
+	img = imaging.CropToAspect(img, thumbnailAspectWidth, thumbnailAspectHeight, focal)
+
+	cropped, err := os.CreateTemp(cfg.scratchDir, "thumbnail-cropped-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(cropped.Name())
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	err = encoder.Encode(cropped, img)
+	if closeErr := cropped.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+	placeholders, err := imaging.MakePlaceholders(img)
+	if err != nil {
+		return err
+	}
+
+	thumbnailID := uuid.New()
+	dir := filepath.Join(cfg.assetsRoot, "thumbnails", thumbnailID.String())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files, err := media.RenderThumbnails(ctx, cfg.media, cropped.Name(), dir, bounds.Dx(), bounds.Dy(), thumbnailWidths)
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("couldn't render thumbnails: %w", err)
+	}
+
+	thumbnails := []database.Thumbnail{}
+	var largestJPEG string
+	for _, f := range files {
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+		url := cfg.assetURL(fmt.Sprintf("thumbnails/%s/%s", thumbnailID, filepath.Base(f.Path)))
+		thumbnails = append(thumbnails, database.Thumbnail{
+			ThumbnailID: thumbnailID,
+			Format:      f.Format,
+			MediaType:   media.ImageMediaType(f.Format),
+			Width:       f.Width,
+			Height:      f.Height,
+			SizeBytes:   info.Size(),
+			URL:         url,
+		})
+		if f.Format == media.ImageFormatJPEG {
+			largestJPEG = url
+		}
+	}
+
+	previous, err := cfg.db.ReplaceThumbnails(video.ID, thumbnails)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	for _, old := range previous {
+		if old.ThumbnailID != thumbnailID {
+			os.RemoveAll(filepath.Join(cfg.assetsRoot, "thumbnails", old.ThumbnailID.String()))
+		}
+	}
+
+	video.Thumbnails, err = cfg.db.GetThumbnails(video.ID)
+	if err != nil {
+		return err
+	}
+	video.ThumbnailURL = &largestJPEG
+	video.ThumbnailBlurHash = &placeholders.BlurHash
+	video.ThumbnailLQIP = &placeholders.LQIP
+	if focal != nil {
+		video.ThumbnailFocalX = &focal.X
+		video.ThumbnailFocalY = &focal.Y
+	} else {
+		video.ThumbnailFocalX = nil
+		video.ThumbnailFocalY = nil
+	}
+	return nil
+}
+
+// convertImage turns an image Go can't decode into a PNG of its first
+// frame with ffmpeg.
+func (cfg *apiConfig) convertImage(ctx context.Context, data []byte) ([]byte, error) {
+	input, err := cfg.receiveFile(bytes.NewReader(data), "thumbnail-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(input.Path)
+
+	unreadable := &imaging.Error{
+		Code:    imaging.CodeCorruptImage,
+		Message: "Image couldn't be decoded; supported types are " + imaging.SupportedTypes,
+	}
+	probe, err := media.Probe(ctx, cfg.media, input.Path)
+	if err != nil {
+		return nil, imageJobError(err, unreadable)
+	}
+	stream, err := probe.VideoStream()
+	if err != nil {
+		return nil, unreadable
+	}
+	if err := imaging.CheckSize(stream.Width, stream.Height, thumbnailLimits); err != nil {
+		return nil, err
+	}
+
+	outputPath := input.Path + ".png"
+	err = media.ConvertImage(ctx, cfg.media, input.Path, outputPath)
+	if err != nil {
+		return nil, imageJobError(err, unreadable)
+	}
+	defer os.Remove(outputPath)
+	return os.ReadFile(outputPath)
+}
+
+// imageJobError reports an ffmpeg or ffprobe run that rejected its input
+// as unreadable, and passes other failures through.
+func imageJobError(err error, unreadable *imaging.Error) error {
+	var jobErr *media.Error
+	if errors.As(err, &jobErr) && !jobErr.TimedOut && jobErr.ExitCode > 0 {
+		return unreadable
+	}
+	return err
+}
 
+// assetURL returns the URL the assets file server serves path at.
+func (cfg *apiConfig) assetURL(path string) string {
+	return cfg.publicURL("/assets/" + path)
 }
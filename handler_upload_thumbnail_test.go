@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+func TestHandlerUploadThumbnailRejectsBadBodies(t *testing.T) {
+	cfg := newTestConfig(t, media.NewFakeRunner())
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "owner@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := func(field string, size int) (*bytes.Buffer, string) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		part, err := mw.CreateFormFile(field, "thumbnail.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(make([]byte, size))
+		mw.Close()
+		return body, mw.FormDataContentType()
+	}
+	tooLarge, tooLargeType := form("thumbnail", int(thumbnailLimits.MaxBytes)+(2<<20))
+	missing, missingType := form("image", 16)
+
+	tests := []struct {
+		name        string
+		body        *bytes.Buffer
+		contentType string
+		wantStatus  int
+	}{
+		{"not multipart", bytes.NewBufferString(`{"thumbnail": ""}`), "application/json", http.StatusBadRequest},
+		{"truncated multipart", bytes.NewBufferString("--x\r\nContent-Disposition: form-data"), "multipart/form-data; boundary=x", http.StatusBadRequest},
+		{"too large", tooLarge, tooLargeType, http.StatusRequestEntityTooLarge},
+		{"missing thumbnail", missing, missingType, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			videoID := uuid.New().String()
+			req := httptest.NewRequest(http.MethodPost, "/api/thumbnail_upload/"+videoID, tt.body)
+			req.SetPathValue("videoID", videoID)
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+
+			cfg.handlerUploadThumbnail(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
@@ -1,40 +1,133 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
 	"github.com/google/uuid"
 	"io"
 	"log"
-	"math"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
-	"strings"
+	"time"
 )
 
-type StreamInfo struct {
-	Width  int `json:"width"`
-	Height int `json:"height"`
+// maxFormValueSize bounds the plain form fields sent alongside the video.
+const maxFormValueSize = 1 << 10
+
+// receivedFile is an uploaded file written to scratch space.
+type receivedFile struct {
+	Path   string
+	Size   int64
+	SHA256 string
 }
 
-type FFProbeOutput struct {
-	Streams []StreamInfo `json:"streams"`
+// receiveFile copies src into a new scratch file, hashing it on the way,
+// so the upload touches the disk exactly once. The caller must remove the
+// file.
+func (cfg *apiConfig) receiveFile(src io.Reader, pattern string) (receivedFile, error) {
+	f, err := os.CreateTemp(cfg.scratchDir, pattern)
+	if err != nil {
+		return receivedFile{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.CopyBuffer(io.MultiWriter(f, hash), src, make([]byte, 1<<20))
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return receivedFile{}, err
+	}
+	return receivedFile{
+		Path:   f.Name(),
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// hashFile describes a file already in scratch space, such as a cut clip,
+// the way receiveFile describes an upload.
+func hashFile(path string) (receivedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return receivedFile{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return receivedFile{}, err
+	}
+	return receivedFile{
+		Path:   path,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// publishUpload runs a validated upload through the pipeline as a new
+// version of video: it reserves a version number, stores the original and
+// the processed files under it, and makes the version current. The caller
+// saves video and the returned version with PublishVideo, then deletes the
+// files the new version replaced, or if saving or publishUpload fails, the
+// files stored for it, with deleteReplacedObjects.
+func (cfg *apiConfig) publishUpload(ctx context.Context, video *database.Video, upload receivedFile, probe media.ProbeResult, opts processingOptions, uploadedBy uuid.UUID) (database.VideoVersion, error) {
+	var err error
+	opts.Version, err = cfg.db.ReserveVideoVersionNumber(video.ID)
+	if err != nil {
+		return database.VideoVersion{}, fmt.Errorf("couldn't reserve video version: %w", err)
+	}
+
+	err = cfg.storeOriginal(ctx, video, upload.Path, probe, opts.Version)
+	if err != nil {
+		return database.VideoVersion{}, err
+	}
+	err = cfg.publishVideo(ctx, video, upload.Path, probe, opts)
+	if err != nil {
+		return database.VideoVersion{}, err
+	}
+	version, err := cfg.newVideoVersion(video, opts.Version, uploadedBy, upload, probe)
+	if err != nil {
+		return database.VideoVersion{}, err
+	}
+
+	// The previous upload's checks don't apply to this one; checkUpload
+	// replaces them once it has been saved.
+	video.SourceSHA256 = &upload.SHA256
+	video.QCVerdict = nil
+	video.DuplicateOfID = nil
+	video.DuplicateDistance = nil
+	return version, nil
+}
+
+// checkUpload checks the quality of an upload that has been published and
+// saved and looks for duplicates of it, then saves their verdicts on video.
+// The quality report and fingerprint describe the current upload, which is
+// why they are only replaced now. Like the checks themselves, it only logs
+// failures.
+func (cfg *apiConfig) checkUpload(ctx context.Context, video *database.Video, path string, probe media.ProbeResult) {
+	cfg.runQualityCheck(ctx, video, path, probe)
+	cfg.checkDuplicates(ctx, video, path, probe)
+	if err := cfg.db.UpdateVideo(*video); err != nil {
+		log.Printf("Couldn't save checks of video %s: %v", video.ID, err)
+	}
 }
 
 // store video to s3 tubely
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const maxMemory = 10 << 30
-	r.Body = http.MaxBytesReader(w, r.Body, maxMemory)
+	const maxUploadSize = 10 << 30
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -45,182 +138,249 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
 	}
 	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
 	}
 	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
+	if err != nil || video.ID == uuid.Nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
 	}
 	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "You can't upload this video", err)
+		respondWithError(w, http.StatusUnauthorized, "You can't upload this video", nil)
+		return
 	}
 
-	file, fileHeader, err := r.FormFile("video")
+	// Read the multipart body part by part rather than with r.FormFile,
+	// which would spool the whole video to disk before we copy it again.
+	reader, err := r.MultipartReader()
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		respondWithError(w, http.StatusBadRequest, "Request must be multipart/form-data", err)
 		return
 	}
-	defer file.Close()
+	started := time.Now()
+	form := url.Values{}
+	var upload *receivedFile
+	defer func() {
+		if upload != nil {
+			os.Remove(upload.Path)
+		}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondWithUploadError(w, "Unable to parse multipart body", err)
+			return
+		}
+
+		if part.FormName() != "video" {
+			if part.FileName() != "" {
+				part.Close()
+				continue
+			}
+			value, err := io.ReadAll(io.LimitReader(part, maxFormValueSize+1))
+			if err != nil {
+				respondWithUploadError(w, "Unable to parse multipart body", err)
+				return
+			}
+			if len(value) > maxFormValueSize {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Form field %q is too long", part.FormName()), nil)
+				return
+			}
+			form.Add(part.FormName(), string(value))
+			continue
+		}
+
+		if upload != nil {
+			respondWithError(w, http.StatusBadRequest, "Only one video can be uploaded at a time", nil)
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+			return
+		}
+		if mediaType != "video/mp4" {
+			respondWithError(w, http.StatusBadRequest, "Only MP4 videos are supported", nil)
+			return
+		}
+		received, err := cfg.receiveFile(part, "video-upload-*.mp4")
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Failed to write file", err)
+			return
+		}
+		upload = &received
+	}
+	if upload == nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", errors.New("missing video part"))
+		return
+	}
+	log.Printf("Received %d bytes for video %s in %s (sha256 %s)", upload.Size, videoIDString, time.Since(started).Round(time.Millisecond), upload.SHA256)
 
-	rawContentType := fileHeader.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(rawContentType)
+	originalTempFilePath := upload.Path
+
+	probe, err := media.Validate(r.Context(), cfg.media, originalTempFilePath, cfg.uploadLimits)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+		var validationErr *media.ValidationError
+		if errors.As(err, &validationErr) {
+			respondWithErrorCode(w, http.StatusUnprocessableEntity, validationErr.Code, validationErr.Message, nil)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to validate video", err)
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Only MP4 videos are supported", nil)
+
+	opts, err := cfg.processingOptionsForUpload(form, userID)
+	if err != nil {
+		respondWithOptionsError(w, err)
 		return
 	}
 
-	//save to a temp file
-	tmpFile, err := os.CreateTemp("", "video-upload-*.mp4")
+	// The new files are stored under keys of their own, so until the
+	// video is saved a failure only has to remove them.
+	saved := video
+	published := false
+	defer func() {
+		if !published {
+			cfg.deleteReplacedObjects(context.WithoutCancel(r.Context()), saved, video)
+		}
+	}()
+
+	version, err := cfg.publishUpload(r.Context(), &video, *upload, probe, opts, userID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to process video", err)
 		return
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
 
-	_, err = io.Copy(tmpFile, file)
+	renditions, err := cfg.db.PublishVideo(video, &version)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to write file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
+	video.AudioRenditions = renditions
+	published = true
+	cfg.checkUpload(r.Context(), &video, upload.Path, probe)
+
+	// Earlier uploads stay available for rollback until they fall out of
+	// the retention limit; files from before versioning are removed now.
+	cfg.pruneVideoVersions(r.Context(), video)
+	cfg.deleteReplacedObjects(r.Context(), video, saved)
 
-	originalTempFilePath := tmpFile.Name()
+	log.Printf("Successfully processed and uploaded video ID %s in %s, key: %s\n", videoIDString, time.Since(started).Round(time.Millisecond), *video.VideoKey)
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
 
-	aspectRatio, err := getVideoAspectRatio(originalTempFilePath)
+// handlerVideoReprocess runs the stored original of a video through the
+// pipeline again with the owner's current processing settings.
+func (cfg *apiConfig) handlerVideoReprocess(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
 	if err != nil {
-		tmpFile.Close()
-		respondWithError(w, http.StatusInternalServerError, "Failed to get video aspect ratio", err)
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
 		return
 	}
-
-	processedFilePath, err := processVideoForFastStart(originalTempFilePath)
+	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to process video for fast start", err)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	defer os.Remove(processedFilePath)
-
-	randomBytes := make([]byte, 16)
-	_, err = rand.Read(randomBytes)
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random bytes", err)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
-	randomHexFileName := hex.EncodeToString(randomBytes)
-	filename := randomHexFileName + ".mp4"
-
-	var prefix string
-	switch aspectRatio {
-	case "16:9":
-		prefix = "landscape"
-	case "9:16":
-		prefix = "portrait"
-	default:
-		prefix = "other"
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
 	}
-
-	key := fmt.Sprintf("%s/%s", prefix, filename)
-
-	processedFile, err := os.Open(processedFilePath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to open processed file for upload", err)
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't reprocess this video", nil)
 		return
 	}
-	defer processedFile.Close()
-
-	putObjectInput := &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(key),
-		Body:        processedFile,
-		ContentType: aws.String(mediaType),
+	if video.SourceKey == nil {
+		respondWithError(w, http.StatusConflict, "Video has no stored original to reprocess", nil)
+		return
 	}
 
-	_, err = cfg.s3Client.PutObject(context.TODO(), putObjectInput)
+	if err := r.ParseForm(); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+		return
+	}
+	opts, err := cfg.processingOptionsForUpload(r.Form, userID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't upload file to s3", err)
+		respondWithOptionsError(w, err)
 		return
 	}
 
-	cfDomain := strings.TrimSuffix(cfg.CfDistributionDomain, "/")
-	publicVideoURL := fmt.Sprintf("https://%s/%s", cfDomain, key)
-	video.VideoURL = &publicVideoURL
-
-	err = cfg.db.UpdateVideo(video)
+	opts.Version, err = cfg.currentVersionNumber(video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video versions", err)
+		return
 	}
 
-	log.Printf("Successfully processed and uploaded video ID %s, URL: %s\n", videoIDString, publicVideoURL)
-	respondWithJSON(w, http.StatusOK, video)
-}
-
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	if err := cmd.Run(); err != nil {
-		return "", err
+	sourcePath, err := cfg.downloadToTemp(r.Context(), *video.SourceKey, "video-source-*.mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't download original video", err)
+		return
 	}
+	defer os.Remove(sourcePath)
 
-	var result struct {
-		Streams []struct {
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		} `json:"streams"`
+	probe, err := media.Probe(r.Context(), cfg.media, sourcePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to probe video", err)
+		return
 	}
 
-	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
-		return "", err
-	}
+	saved := video
+	published := false
+	defer func() {
+		if !published {
+			cfg.deleteReplacedObjects(context.WithoutCancel(r.Context()), saved, video)
+		}
+	}()
 
-	if len(result.Streams) == 0 {
-		return "", errors.New("no video streams found")
+	err = cfg.publishVideo(r.Context(), &video, sourcePath, probe, opts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to process video", err)
+		return
 	}
 
-	width := result.Streams[0].Width
-	height := result.Streams[0].Height
-
-	if width == 0 || height == 0 {
-		return "other", nil
+	version, err := cfg.currentVersionOutput(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video version", err)
+		return
 	}
-
-	ratio := float64(width) / float64(height)
-
-	const epsilon = 0.05
-
-	if math.Abs(ratio-16.0/9.0) < epsilon {
-		return "16:9", nil
-	} else if math.Abs(ratio-9.0/16.0) < epsilon {
-		return "9:16", nil
+	renditions, err := cfg.db.PublishVideo(video, version)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
 	}
+	video.AudioRenditions = renditions
+	published = true
+	cfg.deleteReplacedObjects(r.Context(), video, saved)
 
-	return "other", nil
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
 }
 
-func processVideoForFastStart(inputFilePath string) (string, error) {
-	outputFilePath := inputFilePath + ".faststart"
-	cmd := exec.Command("ffmpeg",
-		"-i", inputFilePath,
-		"-c", "copy",
-		"-bsf:v", "h264_mp4toannexb",
-		"-f", "mp4", "-movflags",
-		"faststart", outputFilePath)
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		os.Remove(outputFilePath)
-		return "", fmt.Errorf("failed to process video for faststart: %s", output)
+// respondWithUploadError reports a failure reading the request body,
+// distinguishing uploads over the size limit.
+func respondWithUploadError(w http.ResponseWriter, msg string, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", err)
+		return
 	}
-	fmt.Printf("Successfully processed video: '%s'\n", outputFilePath)
-
-	return outputFilePath, nil
+	respondWithError(w, http.StatusBadRequest, msg, err)
 }
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// newTestConfig returns a config backed by a temporary database, local
+// store and scratch directory, running media jobs on runner.
+func newTestConfig(t testing.TB, runner media.Runner) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"))
+	if err != nil {
+		t.Fatalf("couldn't open database: %v", err)
+	}
+	store, err := storage.NewLocal(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatalf("couldn't create store: %v", err)
+	}
+	scratchDir := filepath.Join(dir, "scratch")
+	if err := os.Mkdir(scratchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := parseKeyTemplate(defaultVideoKeyTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &apiConfig{
+		db:                 db,
+		jwtSecret:          "test-secret",
+		assetsRoot:         filepath.Join(dir, "assets"),
+		videoStore:         store,
+		publicBaseURL:      "http://localhost:8091",
+		media:              runner,
+		uploadLimits:       media.Limits{MaxDuration: time.Hour},
+		loudnessTargetLUFS: -16,
+		scratchDir:         scratchDir,
+		mediaURLMode:       mediaURLModeAPI,
+		aspectClasses:      defaultAspectClasses(),
+		videoKeyTemplate:   keys,
+	}
+}
+
+// multipartVideo builds an upload request body holding data as the video
+// part.
+func multipartVideo(t testing.TB, data []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="video"; filename="upload.mp4"`)
+	header.Set("Content-Type", "video/mp4")
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body, mw.FormDataContentType()
+}
+
+func TestHandlerUploadVideoRejectsInvalidMedia(t *testing.T) {
+	mkv, err := json.Marshal(media.ProbeResult{
+		Streams: []media.Stream{{CodecType: "video", CodecName: "h264", Width: 1280, Height: 720}},
+		Format:  media.Format{FormatName: "matroska,webm", Duration: "10.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp4, err := json.Marshal(media.ProbeResult{
+		Streams: []media.Stream{{CodecType: "video", CodecName: "h264", Width: 1280, Height: 720, AvgFrameRate: "30/1"}},
+		Format:  media.Format{FormatName: "mov,mp4,m4a,3gp,3g2,mj2", Duration: "10.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		steps []media.FakeStep
+		code  string
+	}{
+		{
+			name:  "unsupported container",
+			steps: []media.FakeStep{{Program: media.ProgramFFprobe, Result: media.Result{Stdout: mkv}}},
+			code:  media.CodeUnsupportedContainer,
+		},
+		{
+			name: "truncated file",
+			steps: []media.FakeStep{
+				{Program: media.ProgramFFprobe, Result: media.Result{Stdout: mp4}},
+				{Program: media.ProgramFFmpeg},
+				{Program: media.ProgramFFmpeg, Err: &media.Error{Program: media.ProgramFFmpeg, ExitCode: 1}},
+			},
+			code: media.CodeTruncated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := media.NewFakeRunner(tt.steps...)
+			cfg := newTestConfig(t, runner)
+
+			user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "owner@example.com", Password: "hash"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+				Title:      "Test video",
+				UserID:     user.ID,
+				Visibility: database.VisibilityPrivate,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, contentType := multipartVideo(t, []byte("not really a video"))
+			req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+video.ID.String(), body)
+			req.SetPathValue("videoID", video.ID.String())
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Content-Type", contentType)
+			rec := httptest.NewRecorder()
+
+			cfg.handlerUploadVideo(rec, req)
+
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, http.StatusUnprocessableEntity, rec.Body)
+			}
+			var resp struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("couldn't decode response: %v", err)
+			}
+			if resp.Code != tt.code {
+				t.Errorf("code = %q, want %q", resp.Code, tt.code)
+			}
+			if n := runner.Remaining(); n != 0 {
+				t.Errorf("%d scripted media jobs weren't run", n)
+			}
+
+			if entries, _ := os.ReadDir(cfg.scratchDir); len(entries) != 0 {
+				t.Errorf("upload left %d files in scratch space", len(entries))
+			}
+			got, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.VideoKey != nil || got.SourceKey != nil {
+				t.Errorf("rejected upload was saved: video key %v, source key %v", got.VideoKey, got.SourceKey)
+			}
+		})
+	}
+}
+
+// mp4File lays out empty MP4 boxes of the given types in order, enough
+// for media.IsFastStart to read.
+func mp4File(boxTypes ...string) []byte {
+	var data []byte
+	for _, boxType := range boxTypes {
+		b := make([]byte, 16)
+		binary.BigEndian.PutUint32(b, uint32(len(b)))
+		copy(b[4:8], boxType)
+		data = append(data, b...)
+	}
+	return data
+}
+
+// writeOutput returns a FakeStep that writes data to the job's output
+// file, its last argument, as ffmpeg would.
+func writeOutput(data []byte) media.FakeStep {
+	return media.FakeStep{
+		Program: media.ProgramFFmpeg,
+		Do: func(job media.Job) (media.Result, error) {
+			return media.Result{}, os.WriteFile(job.Args[len(job.Args)-1], data, 0644)
+		},
+	}
+}
+
+func TestHandlerUploadVideo(t *testing.T) {
+	probe, err := json.Marshal(media.ProbeResult{
+		Streams: []media.Stream{{CodecType: "video", CodecName: "h264", Width: 1280, Height: 720, AvgFrameRate: "30/1"}},
+		Format:  media.Format{FormatName: "mov,mp4,m4a,3gp,3g2,mj2", Duration: "10.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := mp4File("ftyp", "mdat", "moov")
+	watermarked := mp4File("ftyp", "free", "mdat", "moov")
+	published := mp4File("ftyp", "moov", "free", "mdat")
+
+	runner := media.NewFakeRunner(
+		media.FakeStep{Program: media.ProgramFFprobe, Result: media.Result{Stdout: probe}},
+		media.FakeStep{Program: media.ProgramFFmpeg},
+		media.FakeStep{Program: media.ProgramFFmpeg},
+		writeOutput(watermarked),
+		writeOutput(published),
+		media.FakeStep{Program: media.ProgramFFmpeg},
+		media.FakeStep{Program: media.ProgramFFmpeg, Result: media.Result{Stdout: bytes.Repeat([]byte{0x80}, 16*9*8)}},
+	)
+	cfg := newTestConfig(t, runner)
+	cfg.videoKeyTemplate, err = parseKeyTemplate("{user_id}/{video_id}/{version}/{orientation}-{rendition}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "owner@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watermarkKey := user.ID.String() + "/watermark.png"
+	if err := cfg.videoStore.Put(context.Background(), watermarkKey, bytes.NewReader([]byte("png")), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.SetWatermarkKey(user.ID, &watermarkKey); err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.UpdateProcessingSettings(user.ID, database.ProcessingSettings{
+		Watermark: database.WatermarkSettings{Position: media.PositionBottomRight, Margin: 10, Scale: 0.1, Opacity: 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Test video", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, contentType := multipartVideo(t, upload)
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+video.ID.String(), body)
+	req.SetPathValue("videoID", video.ID.String())
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+
+	cfg.handlerUploadVideo(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body %s)", rec.Code, http.StatusOK, rec.Body)
+	}
+	if n := runner.Remaining(); n != 0 {
+		t.Errorf("%d scripted media jobs weren't run", n)
+	}
+
+	// The upload is probed and decoded in place, watermarked, remuxed for
+	// fast start and then checked, all from the file received.
+	calls := runner.Calls()
+	if len(calls) != 7 {
+		t.Fatalf("runner got %d calls, want 7", len(calls))
+	}
+	uploadPath := calls[0].Args[len(calls[0].Args)-1]
+	input := func(job media.Job) string {
+		for i, arg := range job.Args {
+			if arg == "-i" {
+				return job.Args[i+1]
+			}
+		}
+		return ""
+	}
+	for i, job := range calls[1:3] {
+		if input(job) != uploadPath || !slices.Contains(job.Args, "null") {
+			t.Errorf("call %d = %v, want a decode test of %s", i+2, job.Args, uploadPath)
+		}
+	}
+	watermark := calls[3]
+	if input(watermark) != uploadPath || !slices.Contains(watermark.Args, "-filter_complex") {
+		t.Errorf("watermark call = %v, want an overlay on %s", watermark.Args, uploadPath)
+	}
+	watermarkedPath := watermark.Args[len(watermark.Args)-1]
+	fastStart := calls[4]
+	if input(fastStart) != watermarkedPath || !slices.Contains(fastStart.Args, "faststart") {
+		t.Errorf("fast-start call = %v, want a remux of %s", fastStart.Args, watermarkedPath)
+	}
+	for i, job := range calls[5:] {
+		if input(job) != uploadPath {
+			t.Errorf("check %d read %s, want the upload %s", i+1, input(job), uploadPath)
+		}
+	}
+
+	got, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := user.ID.String() + "/" + video.ID.String() + "/1/landscape-"
+	if got.SourceKey == nil || *got.SourceKey != prefix+"original.mp4" {
+		t.Errorf("source key = %v, want %s", got.SourceKey, prefix+"original.mp4")
+	}
+	if got.VideoKey == nil || *got.VideoKey != prefix+"video.mp4" {
+		t.Fatalf("video key = %v, want %s", got.VideoKey, prefix+"video.mp4")
+	}
+	if got.DurationSeconds == nil || *got.DurationSeconds != 10 {
+		t.Errorf("duration = %v, want 10", got.DurationSeconds)
+	}
+	sum := sha256.Sum256(upload)
+	if got.SourceSHA256 == nil || *got.SourceSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("source SHA-256 = %v, want %x", got.SourceSHA256, sum)
+	}
+	if got.QCVerdict == nil || *got.QCVerdict != media.VerdictPass {
+		t.Errorf("QC verdict = %v, want %s", got.QCVerdict, media.VerdictPass)
+	}
+
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || got.CurrentVersionID == nil || versions[0].ID != *got.CurrentVersionID {
+		t.Fatalf("versions = %+v, current %v, want one current version", versions, got.CurrentVersionID)
+	}
+	if v := versions[0]; v.Number != 1 || v.SourceKey != *got.SourceKey || v.VideoKey != *got.VideoKey || v.SizeBytes != int64(len(upload)) {
+		t.Errorf("version = %+v", v)
+	}
+
+	stored := map[string][]byte{*got.SourceKey: upload, *got.VideoKey: published}
+	for key, want := range stored {
+		body, err := cfg.videoStore.Get(context.Background(), key)
+		if err != nil {
+			t.Fatalf("couldn't get %s: %v", key, err)
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Errorf("%s holds %x, want %x", key, data, want)
+		}
+	}
+	if entries, _ := os.ReadDir(cfg.scratchDir); len(entries) != 0 {
+		t.Errorf("upload left %d files in scratch space", len(entries))
+	}
+}
+
+// benchmarkUpload runs receive against a 64 MiB upload, which is large
+// enough that r.FormFile spools it to disk.
+func benchmarkUpload(b *testing.B, receive func(*testing.B, *apiConfig, *http.Request) receivedFile) {
+	cfg := &apiConfig{scratchDir: b.TempDir()}
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4<<20)
+	body, contentType := multipartVideo(b, data)
+	raw := body.Bytes()
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/video_upload/x", bytes.NewReader(raw))
+		req.Header.Set("Content-Type", contentType)
+		received := receive(b, cfg, req)
+		if received.Size != int64(len(data)) {
+			b.Fatalf("received %d bytes, want %d", received.Size, len(data))
+		}
+		os.Remove(received.Path)
+	}
+}
+
+// BenchmarkReceiveUpload streams the video part to scratch space, hashing
+// it on the way, as handlerUploadVideo does.
+func BenchmarkReceiveUpload(b *testing.B) {
+	benchmarkUpload(b, func(b *testing.B, cfg *apiConfig, r *http.Request) receivedFile {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			b.Fatal(err)
+		}
+		part, err := reader.NextPart()
+		if err != nil {
+			b.Fatal(err)
+		}
+		received, err := cfg.receiveFile(part, "video-upload-*.mp4")
+		if err != nil {
+			b.Fatal(err)
+		}
+		return received
+	})
+}
+
+// BenchmarkFormFileUpload is the path handlerUploadVideo used to take:
+// r.FormFile spools the part to disk, it is copied to a second temporary
+// file and then read again for its hash.
+func BenchmarkFormFileUpload(b *testing.B) {
+	benchmarkUpload(b, func(b *testing.B, cfg *apiConfig, r *http.Request) receivedFile {
+		file, _, err := r.FormFile("video")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer r.MultipartForm.RemoveAll()
+		defer file.Close()
+
+		tmpFile, err := os.CreateTemp(cfg.scratchDir, "video-upload-*.mp4")
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = io.Copy(tmpFile, file)
+		tmpFile.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+		received, err := hashFile(tmpFile.Name())
+		if err != nil {
+			b.Fatal(err)
+		}
+		return received
+	})
+}
+
+// scratchRecorder wraps a Runner to note the size of every file that
+// appears in the scratch directory around each job, which adds up to what
+// an upload writes there since nothing is created after the last job.
+type scratchRecorder struct {
+	media.Runner
+	dir   string
+	sizes map[string]int64
+}
+
+func (s *scratchRecorder) Run(ctx context.Context, job media.Job) (media.Result, error) {
+	s.record()
+	defer s.record()
+	return s.Runner.Run(ctx, job)
+}
+
+func (s *scratchRecorder) record() {
+	entries, _ := os.ReadDir(s.dir)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			s.sizes[entry.Name()] = max(s.sizes[entry.Name()], info.Size())
+		}
+	}
+}
+
+// BenchmarkUploadPipeline runs handlerUploadVideo end to end on a 64 MiB
+// upload with nothing to process, reporting the bytes written to scratch
+// space and the media jobs run per upload. An upload that already starts
+// with its moov atom is received once and published as-is; one that
+// doesn't also pays for the fast-start remux.
+func BenchmarkUploadPipeline(b *testing.B) {
+	probe, err := json.Marshal(media.ProbeResult{
+		Streams: []media.Stream{{CodecType: "video", CodecName: "h264", Width: 1280, Height: 720, AvgFrameRate: "30/1"}},
+		Format:  media.Format{FormatName: "mov,mp4,m4a,3gp,3g2,mj2", Duration: "10.0"},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	mdat := make([]byte, 64<<20)
+	binary.BigEndian.PutUint32(mdat, uint32(len(mdat)))
+	copy(mdat[4:8], "mdat")
+
+	tests := []struct {
+		name     string
+		upload   []byte
+		remuxed  bool
+		wantJobs int
+	}{
+		{"faststart", append(mp4File("ftyp", "moov"), mdat...), false, 5},
+		{"remux", append(append(mp4File("ftyp"), mdat...), mp4File("moov")...), true, 6},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			cfg := newTestConfig(b, nil)
+			cfg.maxVideoVersions = 1
+			user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "owner@example.com", Password: "hash"})
+			if err != nil {
+				b.Fatal(err)
+			}
+			video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Test video", UserID: user.ID})
+			if err != nil {
+				b.Fatal(err)
+			}
+			token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+			if err != nil {
+				b.Fatal(err)
+			}
+			body, contentType := multipartVideo(b, tt.upload)
+			raw := body.Bytes()
+
+			var scratchBytes int64
+			jobs := 0
+			b.SetBytes(int64(len(tt.upload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				steps := []media.FakeStep{
+					{Program: media.ProgramFFprobe, Result: media.Result{Stdout: probe}},
+					{Program: media.ProgramFFmpeg},
+					{Program: media.ProgramFFmpeg},
+				}
+				if tt.remuxed {
+					steps = append(steps, writeOutput(tt.upload))
+				}
+				steps = append(steps,
+					media.FakeStep{Program: media.ProgramFFmpeg},
+					media.FakeStep{Program: media.ProgramFFmpeg, Result: media.Result{Stdout: bytes.Repeat([]byte{0x80}, 16*9*8)}},
+				)
+				runner := media.NewFakeRunner(steps...)
+				recorder := &scratchRecorder{Runner: runner, dir: cfg.scratchDir, sizes: map[string]int64{}}
+				cfg.media = recorder
+
+				req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+video.ID.String(), bytes.NewReader(raw))
+				req.SetPathValue("videoID", video.ID.String())
+				req.Header.Set("Authorization", "Bearer "+token)
+				req.Header.Set("Content-Type", contentType)
+				rec := httptest.NewRecorder()
+				cfg.handlerUploadVideo(rec, req)
+				if rec.Code != http.StatusOK {
+					b.Fatalf("status = %d (body %s)", rec.Code, rec.Body)
+				}
+
+				jobs += len(runner.Calls())
+				for _, size := range recorder.sizes {
+					scratchBytes += size
+				}
+			}
+			b.ReportMetric(float64(scratchBytes)/float64(b.N), "scratch-B/op")
+			b.ReportMetric(float64(jobs)/float64(b.N), "jobs/op")
+			if jobs != tt.wantJobs*b.N {
+				b.Errorf("ran %d media jobs per upload, want %d", jobs/b.N, tt.wantJobs)
+			}
+		})
+	}
+}
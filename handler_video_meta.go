@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -33,6 +38,13 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	params.UserID = userID
+	if params.Visibility == "" {
+		params.Visibility = database.VisibilityUnlisted
+	}
+	if !validVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, visibilityError, nil)
+		return
+	}
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
@@ -40,7 +52,7 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, video)
+	cfg.respondWithVideo(w, r, http.StatusCreated, video)
 }
 
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
@@ -72,30 +84,101 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// The versions naming some of the video's files go with the row, so
+	// collect its keys first.
+	keys, err := cfg.videoObjectKeys(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video files", err)
+		return
+	}
 	err = cfg.db.DeleteVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	cfg.deleteVideoFiles(r.Context(), video, keys)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+// videoObjectKeys lists every key in the video store that belongs to
+// video: its originals and processed files, including those of earlier
+// versions, its audio renditions, caption and chapter tracks, and the
+// uncropped thumbnail.
+func (cfg *apiConfig) videoObjectKeys(video database.Video) ([]string, error) {
+	versions, err := cfg.db.GetVideoVersions(video.ID)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
-		return
+		return nil, err
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	var keys []string
+	for _, key := range []*string{video.SourceKey, video.VideoKey, video.ChaptersKey, video.ThumbnailSourceKey} {
+		if key != nil {
+			keys = append(keys, *key)
+		}
+	}
+	if video.VideoKey == nil && video.VideoURL != nil {
+		if key := cfg.keyFromURL(*video.VideoURL); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	for _, v := range versions {
+		keys = append(keys, v.SourceKey, v.VideoKey)
+	}
+	for _, rendition := range video.AudioRenditions {
+		keys = append(keys, rendition.Key)
+	}
+	for _, track := range video.Captions {
+		keys = append(keys, track.Key)
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys), nil
+}
+
+// discardVideo deletes a video created by a request that then failed,
+// along with whatever files were stored for it.
+func (cfg *apiConfig) discardVideo(ctx context.Context, video database.Video) {
+	keys, err := cfg.videoObjectKeys(video)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		log.Printf("Couldn't get files of discarded video %s: %v", video.ID, err)
+	}
+	if err := cfg.db.DeleteVideo(video.ID); err != nil {
+		log.Printf("Couldn't delete discarded video %s: %v", video.ID, err)
+		return
+	}
+	cfg.deleteVideoFiles(ctx, video, keys)
+}
+
+// deleteVideoFiles removes the files of a deleted video: keys from the
+// video store and its rendered thumbnails from the assets directory.
+// Failures are logged, as the video itself is already gone.
+func (cfg *apiConfig) deleteVideoFiles(ctx context.Context, video database.Video, keys []string) {
+	for _, key := range keys {
+		if err := cfg.videoStore.Delete(ctx, key); err != nil {
+			log.Printf("Couldn't delete %s of deleted video %s: %v", key, video.ID, err)
+		}
+	}
+	thumbnailIDs := map[uuid.UUID]bool{}
+	for _, t := range video.Thumbnails {
+		thumbnailIDs[t.ThumbnailID] = true
+	}
+	for id := range thumbnailIDs {
+		if err := os.RemoveAll(filepath.Join(cfg.assetsRoot, "thumbnails", id.String())); err != nil {
+			log.Printf("Couldn't delete thumbnails of deleted video %s: %v", video.ID, err)
+		}
+	}
+}
+
+// handlerVideoGet returns a video to anyone allowed to watch it. Private
+// videos need their owner's token; without it they look like they don't
+// exist.
+func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.viewableVideo(w, r)
+	if !ok {
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
@@ -116,6 +199,6 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	cfg.respondWithVideos(w, r, http.StatusOK, videos)
 
 }
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoVersionsList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r, "You can't view versions of this video")
+	if !ok {
+		return
+	}
+
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get versions", err)
+		return
+	}
+
+	for i := range versions {
+		versions[i].VideoURL = cfg.mediaURL(r.Context(), video, versions[i].VideoKey)
+	}
+	respondWithJSON(w, http.StatusOK, versions)
+}
+
+// handlerVideoVersionRestore makes an earlier upload of a video current
+// again. The version's files are published as they were; audio renditions
+// and the chapters track are rebuilt to match.
+func (cfg *apiConfig) handlerVideoVersionRestore(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r, "You can't restore versions of this video")
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid version number", err)
+		return
+	}
+
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get versions", err)
+		return
+	}
+	var version database.VideoVersion
+	for _, v := range versions {
+		if v.Number == number {
+			version = v
+		}
+	}
+	if version.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find version", nil)
+		return
+	}
+	if video.CurrentVersionID != nil && *video.CurrentVersionID == version.ID {
+		respondWithError(w, http.StatusConflict, "Version is already current", nil)
+		return
+	}
+	// Key templates accepted before {version} was required could reuse
+	// keys across uploads, in which case a later upload has overwritten
+	// this version's files.
+	for _, v := range versions {
+		if v.Number > version.Number && (v.SourceKey == version.SourceKey || v.VideoKey == version.VideoKey) {
+			respondWithError(w, http.StatusConflict, "Version's files were overwritten by a later upload", nil)
+			return
+		}
+	}
+
+	saved := video
+	published := false
+	defer func() {
+		if !published {
+			cfg.deleteReplacedObjects(context.WithoutCancel(r.Context()), saved, video)
+		}
+	}()
+
+	video.SourceKey = &version.SourceKey
+	video.SourceSHA256 = &version.SHA256
+	video.VideoKey = &version.VideoKey
+	video.VideoURL = cfg.storedURL(version.VideoKey)
+	video.DurationSeconds = &version.DurationSeconds
+	video.LoudnessMeasuredLUFS = version.LoudnessMeasuredLUFS
+	video.LoudnessTargetLUFS = version.LoudnessTargetLUFS
+	video.CurrentVersionID = &version.ID
+
+	if len(video.AudioRenditions) > 0 {
+		err = cfg.republishAudioRenditions(r.Context(), &video, version.Number)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't rebuild audio renditions", err)
+			return
+		}
+	}
+	if len(video.Chapters) > 0 || video.ChaptersKey != nil {
+		err = cfg.publishChapterTrack(r.Context(), &video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't publish chapters track", err)
+			return
+		}
+	}
+
+	renditions, err := cfg.db.PublishVideo(video, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	video.AudioRenditions = renditions
+	published = true
+	cfg.deleteReplacedObjects(r.Context(), video, saved)
+
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
+
+// ownedVideo loads the video named in the path and checks the caller owns
+// it, responding with an error and returning false if not.
+func (cfg *apiConfig) ownedVideo(w http.ResponseWriter, r *http.Request, forbidden string) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, forbidden, nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// newVideoVersion describes the upload just published to video as version
+// number and makes it current. The caller saves both with PublishVideo.
+func (cfg *apiConfig) newVideoVersion(video *database.Video, number int, uploadedBy uuid.UUID, upload receivedFile, probe media.ProbeResult) (database.VideoVersion, error) {
+	stream, err := probe.VideoStream()
+	if err != nil {
+		return database.VideoVersion{}, err
+	}
+	version := database.VideoVersion{
+		ID:                   uuid.New(),
+		VideoID:              video.ID,
+		Number:               number,
+		UploadedBy:           uploadedBy,
+		SourceKey:            *video.SourceKey,
+		VideoKey:             *video.VideoKey,
+		VideoURL:             cfg.objectURL(*video.VideoKey),
+		SHA256:               upload.SHA256,
+		SizeBytes:            upload.Size,
+		DurationSeconds:      probe.Duration(),
+		Width:                stream.Width,
+		Height:               stream.Height,
+		Codec:                stream.CodecName,
+		BitRate:              probe.BitRate(),
+		LoudnessMeasuredLUFS: video.LoudnessMeasuredLUFS,
+		LoudnessTargetLUFS:   video.LoudnessTargetLUFS,
+	}
+	video.CurrentVersionID = &version.ID
+	return version, nil
+}
+
+// currentVersionNumber returns the number of the video's current version,
+// or 0 if it was published before versions were recorded.
+func (cfg *apiConfig) currentVersionNumber(video database.Video) (int, error) {
+	if video.CurrentVersionID == nil {
+		return 0, nil
+	}
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range versions {
+		if v.ID == *video.CurrentVersionID {
+			return v.Number, nil
+		}
+	}
+	return 0, nil
+}
+
+// currentVersionOutput returns the video's current version pointed at the
+// processed file just published for it, e.g. after a reprocess, for
+// PublishVideo to save. It returns nil if the video has no versions.
+func (cfg *apiConfig) currentVersionOutput(video database.Video) (*database.VideoVersion, error) {
+	if video.CurrentVersionID == nil {
+		return nil, nil
+	}
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if v.ID == *video.CurrentVersionID {
+			v.VideoKey = *video.VideoKey
+			v.VideoURL = cfg.objectURL(*video.VideoKey)
+			v.LoudnessMeasuredLUFS = video.LoudnessMeasuredLUFS
+			v.LoudnessTargetLUFS = video.LoudnessTargetLUFS
+			return &v, nil
+		}
+	}
+	return nil, nil
+}
+
+// pruneVideoVersions removes the oldest versions beyond the retention
+// limit, never the current one, along with files nothing else uses.
+func (cfg *apiConfig) pruneVideoVersions(ctx context.Context, video database.Video) {
+	if cfg.maxVideoVersions <= 0 {
+		return
+	}
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		log.Printf("Couldn't get versions of video %s: %v", video.ID, err)
+		return
+	}
+
+	kept := 0
+	var keys []*string
+	for _, v := range versions {
+		current := video.CurrentVersionID != nil && *video.CurrentVersionID == v.ID
+		if current || kept < cfg.maxVideoVersions-1 {
+			if !current {
+				kept++
+			}
+			continue
+		}
+		if err := cfg.db.DeleteVideoVersion(v.ID); err != nil {
+			log.Printf("Couldn't delete version %d of video %s: %v", v.Number, video.ID, err)
+			continue
+		}
+		keys = append(keys, &v.SourceKey, &v.VideoKey)
+	}
+	cfg.deleteUnreferencedObjects(ctx, video, keys...)
+}
+
+// deleteUnreferencedObjects deletes each of keys from the video store
+// unless the video or one of its versions still uses it.
+func (cfg *apiConfig) deleteUnreferencedObjects(ctx context.Context, video database.Video, keys ...*string) {
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		log.Printf("Couldn't get versions of video %s: %v", video.ID, err)
+		return
+	}
+	inUse := map[string]bool{}
+	for _, key := range []*string{video.SourceKey, video.VideoKey} {
+		if key != nil {
+			inUse[*key] = true
+		}
+	}
+	for _, v := range versions {
+		inUse[v.SourceKey] = true
+		inUse[v.VideoKey] = true
+	}
+
+	for _, key := range keys {
+		if key == nil || inUse[*key] {
+			continue
+		}
+		inUse[*key] = true
+		if err := cfg.videoStore.Delete(ctx, *key); err != nil {
+			log.Printf("Couldn't delete %s: %v", *key, err)
+		}
+	}
+}
+
+// deleteReplacedObjects deletes the files from refers to that keep
+// doesn't: once a change to a video is saved, the files it replaced, or
+// once it has failed, the files stored for it. Files of the video's
+// recorded versions are kept either way.
+func (cfg *apiConfig) deleteReplacedObjects(ctx context.Context, keep, from database.Video) {
+	inUse, err := cfg.videoObjectKeys(keep)
+	if err != nil {
+		log.Printf("Couldn't get files of video %s: %v", keep.ID, err)
+		return
+	}
+	keys, err := cfg.videoObjectKeys(from)
+	if err != nil {
+		log.Printf("Couldn't get files of video %s: %v", from.ID, err)
+		return
+	}
+	for _, key := range keys {
+		if _, found := slices.BinarySearch(inUse, key); found {
+			continue
+		}
+		if err := cfg.videoStore.Delete(ctx, key); err != nil {
+			log.Printf("Couldn't delete %s: %v", key, err)
+		}
+	}
+}
+
+// republishAudioRenditions rebuilds the video's audio renditions, in the
+// formats it already has, from its current processed file, which belongs
+// to the given version.
+func (cfg *apiConfig) republishAudioRenditions(ctx context.Context, video *database.Video, version int) error {
+	formats := []string{}
+	for _, rendition := range video.AudioRenditions {
+		formats = append(formats, rendition.Format)
+	}
+
+	path, err := cfg.downloadToTemp(ctx, *video.VideoKey, "video-processed-*.mp4")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	probe, err := media.Probe(ctx, cfg.media, path)
+	if err != nil {
+		return err
+	}
+	return cfg.publishAudioRenditions(ctx, video, path, probe, processingOptions{AudioFormats: formats, Version: version})
+}
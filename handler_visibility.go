@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPublicVideosLimit = 20
+	maxPublicVideosLimit     = 100
+)
+
+const visibilityError = "visibility must be public, unlisted or private"
+
+func validVisibility(v string) bool {
+	switch v {
+	case database.VisibilityPublic, database.VisibilityUnlisted, database.VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// handlerVideosPublic lists public videos for anyone, newest first, a page
+// at a time with the limit and offset query parameters.
+func (cfg *apiConfig) handlerVideosPublic(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultPublicVideosLimit)
+	if err != nil || limit < 1 || limit > maxPublicVideosLimit {
+		respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPublicVideosLimit), err)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "offset must not be negative", err)
+		return
+	}
+
+	videos, err := cfg.db.GetPublicVideos(limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	cfg.respondWithVideos(w, r, http.StatusOK, videos)
+}
+
+func (cfg *apiConfig) handlerVideoVisibilityUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Visibility string `json:"visibility"`
+	}
+
+	video, ok := cfg.ownedVideo(w, r, "You can't change this video's visibility")
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !validVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, visibilityError, nil)
+		return
+	}
+
+	video.Visibility = params.Visibility
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	cfg.respondWithVideo(w, r, http.StatusOK, video)
+}
+
+// viewableVideo loads the video named in the path and checks the caller
+// may watch it, responding with an error and returning false if not. The
+// bearer token is optional; an invalid one is still rejected. Private
+// videos are reported missing to everyone but their owner.
+func (cfg *apiConfig) viewableVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+	viewerID, err := cfg.optionalViewer(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.Visibility == database.VisibilityPrivate && video.UserID != viewerID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// optionalViewer returns the user the request's bearer token belongs to,
+// or uuid.Nil if it has none.
+func (cfg *apiConfig) optionalViewer(r *http.Request) (uuid.UUID, error) {
+	if r.Header.Get("Authorization") == "" {
+		return uuid.Nil, nil
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return auth.ValidateJWT(token, cfg.jwtSecret)
+}
+
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(s)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+const (
+	maxWatermarkBytes     = 5 << 20
+	maxWatermarkDimension = 4096
+)
+
+func (cfg *apiConfig) handlerWatermarkUpload(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWatermarkBytes+(1<<20))
+	file, _, err := r.FormFile("watermark")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxWatermarkBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read watermark", err)
+		return
+	}
+	if len(data) > maxWatermarkBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Watermark image is too large", nil)
+		return
+	}
+
+	imgConfig, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Watermark must be a PNG image", err)
+		return
+	}
+	if imgConfig.Width > maxWatermarkDimension || imgConfig.Height > maxWatermarkDimension {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Watermark must be at most %dx%d pixels", maxWatermarkDimension, maxWatermarkDimension), nil)
+		return
+	}
+	if !hasAlphaChannel(imgConfig.ColorModel) {
+		respondWithError(w, http.StatusBadRequest, "Watermark PNG must have an alpha channel", nil)
+		return
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Watermark PNG is corrupt", err)
+		return
+	}
+
+	key, err := cfg.videoKeyTemplate.renderSidecar(userID, uuid.Nil, kindWatermark, "", ".png")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build storage key", err)
+		return
+	}
+
+	err = cfg.videoStore.Put(r.Context(), key, bytes.NewReader(data), "image/png")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store watermark", err)
+		return
+	}
+
+	settings, err := cfg.db.GetProcessingSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing settings", err)
+		return
+	}
+	err = cfg.db.SetWatermarkKey(userID, &key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save watermark", err)
+		return
+	}
+	cfg.deleteWatermarkObject(r, settings.Watermark.Key)
+
+	settings.Watermark.Key = &key
+	settings.Watermark.HasImage = true
+	respondWithJSON(w, http.StatusOK, settings)
+}
+
+func (cfg *apiConfig) handlerWatermarkDelete(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	settings, err := cfg.db.GetProcessingSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing settings", err)
+		return
+	}
+	err = cfg.db.SetWatermarkKey(userID, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove watermark", err)
+		return
+	}
+	cfg.deleteWatermarkObject(r, settings.Watermark.Key)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) deleteWatermarkObject(r *http.Request, key *string) {
+	if key == nil {
+		return
+	}
+	if err := cfg.videoStore.Delete(r.Context(), *key); err != nil {
+		log.Printf("Couldn't delete watermark %s: %v", *key, err)
+	}
+}
+
+func hasAlphaChannel(model color.Model) bool {
+	switch model {
+	case color.NRGBAModel, color.RGBAModel, color.NRGBA64Model, color.RGBA64Model:
+		return true
+	}
+	if palette, ok := model.(color.Palette); ok {
+		for _, c := range palette {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package captions
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatSRT    = "srt"
+	FormatWebVTT = "vtt"
+)
+
+// Cue is a single timed caption.
+type Cue struct {
+	ID    string
+	Start time.Duration
+	End   time.Duration
+	// Settings holds WebVTT cue settings such as "line:0 align:start".
+	Settings string
+	Text     string
+}
+
+// ParseError reports the line at which a caption file stopped making sense.
+type ParseError struct {
+	Format string
+	Line   int
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid %s at line %d: %s", e.Format, e.Line, e.Msg)
+}
+
+// DetectFormat guesses whether data is WebVTT or SRT from its contents.
+func DetectFormat(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if bytes.HasPrefix(data, []byte("WEBVTT")) {
+		return FormatWebVTT
+	}
+	return FormatSRT
+}
+
+var (
+	srtTimingPattern = regexp.MustCompile(`^(\d{1,2}:\d{2}:\d{2}[,.]\d{1,3})\s*-->\s*(\d{1,2}:\d{2}:\d{2}[,.]\d{1,3})`)
+	vttTimingPattern = regexp.MustCompile(`^((?:\d{2,}:)?\d{2}:\d{2}\.\d{3})\s+-->\s+((?:\d{2,}:)?\d{2}:\d{2}\.\d{3})(?:\s+(.*))?$`)
+	srtFontTag       = regexp.MustCompile(`(?i)</?font[^>]*>`)
+	// cueMarkup matches the character references, tags and timestamps
+	// WebVTT cue text may contain.
+	cueMarkup = regexp.MustCompile(`^(?:&(?:amp|lt|gt|lrm|rlm|nbsp|#[0-9]+|#[xX][0-9a-fA-F]+);|</?(?:[cbiuv]|lang|ruby|rt)(?:[.\s][^<>&]*)?>|<(?:\d{2,}:)?\d{2}:\d{2}\.\d{3}>)`)
+)
+
+// ParseSRT parses a SubRip file.
+func ParseSRT(data []byte) ([]Cue, error) {
+	blocks := splitBlocks(data)
+	cues := []Cue{}
+	for _, b := range blocks {
+		lines := b.lines
+		lineNo := b.start
+		id := ""
+		if len(lines) > 0 && !strings.Contains(lines[0], "-->") {
+			id = strings.TrimSpace(lines[0])
+			if _, err := strconv.Atoi(id); err != nil {
+				return nil, &ParseError{FormatSRT, lineNo, "expected a cue number"}
+			}
+			lines = lines[1:]
+			lineNo++
+		}
+		if len(lines) == 0 {
+			return nil, &ParseError{FormatSRT, lineNo, "cue is missing its timing line"}
+		}
+		m := srtTimingPattern.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		if m == nil {
+			return nil, &ParseError{FormatSRT, lineNo, "expected a timing line like 00:00:01,000 --> 00:00:02,500"}
+		}
+		start, err := parseTimestamp(strings.Replace(m[1], ",", ".", 1))
+		if err != nil {
+			return nil, &ParseError{FormatSRT, lineNo, err.Error()}
+		}
+		end, err := parseTimestamp(strings.Replace(m[2], ",", ".", 1))
+		if err != nil {
+			return nil, &ParseError{FormatSRT, lineNo, err.Error()}
+		}
+		if end <= start {
+			return nil, &ParseError{FormatSRT, lineNo, "cue ends before it starts"}
+		}
+		text := srtFontTag.ReplaceAllString(strings.Join(lines[1:], "\n"), "")
+		cues = append(cues, Cue{ID: id, Start: start, End: end, Text: text})
+	}
+	if len(cues) == 0 {
+		return nil, &ParseError{FormatSRT, 1, "file has no cues"}
+	}
+	return cues, nil
+}
+
+// ParseVTT parses and validates a WebVTT file. NOTE, STYLE and REGION
+// blocks are skipped.
+func ParseVTT(data []byte) ([]Cue, error) {
+	blocks := splitBlocks(data)
+	if len(blocks) == 0 || !isVTTHeader(blocks[0].lines[0]) {
+		return nil, &ParseError{FormatWebVTT, 1, `file must start with "WEBVTT"`}
+	}
+
+	cues := []Cue{}
+	for _, b := range blocks[1:] {
+		first := b.lines[0]
+		if strings.HasPrefix(first, "NOTE") || first == "STYLE" || first == "REGION" {
+			continue
+		}
+		lines := b.lines
+		lineNo := b.start
+		id := ""
+		if !strings.Contains(lines[0], "-->") {
+			id = lines[0]
+			lines = lines[1:]
+			lineNo++
+		}
+		if len(lines) == 0 {
+			return nil, &ParseError{FormatWebVTT, lineNo, "cue is missing its timing line"}
+		}
+		m := vttTimingPattern.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		if m == nil {
+			return nil, &ParseError{FormatWebVTT, lineNo, "expected a timing line like 00:01.000 --> 00:02.500"}
+		}
+		start, err := parseTimestamp(m[1])
+		if err != nil {
+			return nil, &ParseError{FormatWebVTT, lineNo, err.Error()}
+		}
+		end, err := parseTimestamp(m[2])
+		if err != nil {
+			return nil, &ParseError{FormatWebVTT, lineNo, err.Error()}
+		}
+		if end <= start {
+			return nil, &ParseError{FormatWebVTT, lineNo, "cue ends before it starts"}
+		}
+		cues = append(cues, Cue{
+			ID:       id,
+			Start:    start,
+			End:      end,
+			Settings: m[3],
+			Text:     strings.Join(lines[1:], "\n"),
+		})
+	}
+	return cues, nil
+}
+
+// FormatVTT renders cues as a WebVTT file. Cue text may use WebVTT
+// markup; an & or < that doesn't start any, as is common in SRT files, is
+// escaped.
+func FormatVTT(cues []Cue) []byte {
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		b.WriteString("\n")
+		if cue.ID != "" {
+			b.WriteString(cue.ID + "\n")
+		}
+		b.WriteString(formatTimestamp(cue.Start) + " --> " + formatTimestamp(cue.End))
+		if cue.Settings != "" {
+			b.WriteString(" " + cue.Settings)
+		}
+		b.WriteString("\n")
+		if cue.Text != "" {
+			b.WriteString(escapeCueText(cue.Text) + "\n")
+		}
+	}
+	return b.Bytes()
+}
+
+// EscapeText escapes plain text, such as a chapter title, for use as the
+// text of a Cue.
+func EscapeText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// escapeCueText escapes each & and < in s that doesn't start WebVTT
+// markup, and the "-->" that would end a cue's text early.
+func escapeCueText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '&' || c == '<' {
+			if m := cueMarkup.FindString(s[i:]); m != "" {
+				b.WriteString(m)
+				i += len(m) - 1
+				continue
+			}
+		}
+		switch {
+		case c == '&':
+			b.WriteString("&amp;")
+		case c == '<':
+			b.WriteString("&lt;")
+		case c == '>' && strings.HasSuffix(s[:i], "--"):
+			b.WriteString("&gt;")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ToVTT validates data as WebVTT or SRT and returns it as WebVTT. Valid
+// WebVTT input is returned unchanged so styling blocks survive.
+func ToVTT(data []byte) ([]byte, error) {
+	if DetectFormat(data) == FormatWebVTT {
+		if _, err := ParseVTT(data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	cues, err := ParseSRT(data)
+	if err != nil {
+		return nil, err
+	}
+	return FormatVTT(cues), nil
+}
+
+type block struct {
+	start int
+	lines []string
+}
+
+// splitBlocks splits a caption file into blank-line separated blocks,
+// remembering the 1-based line each block starts on.
+func splitBlocks(data []byte) []block {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	blocks := []block{}
+	var current *block
+	for i, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			current = nil
+			continue
+		}
+		if current == nil {
+			blocks = append(blocks, block{start: i + 1})
+			current = &blocks[len(blocks)-1]
+		}
+		current.lines = append(current.lines, line)
+	}
+	return blocks
+}
+
+func isVTTHeader(line string) bool {
+	return line == "WEBVTT" || strings.HasPrefix(line, "WEBVTT ") || strings.HasPrefix(line, "WEBVTT\t")
+}
+
+// parseTimestamp parses "[hh:]mm:ss.ttt".
+func parseTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	hours := 0
+	if len(parts) == 3 {
+		h, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		hours = h
+		parts = parts[1:]
+	}
+	minutes, err := strconv.Atoi(parts[0])
+	if err != nil || minutes > 59 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	seconds, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || seconds >= 60 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	total := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+	return total + time.Duration(seconds*float64(time.Second)+0.5), nil
+}
+
+func formatTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
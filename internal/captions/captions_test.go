@@ -0,0 +1,284 @@
+package captions
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ms(n int) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
+
+func TestParseSRT(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		want     []Cue
+		wantLine int
+	}{
+		{
+			name: "basic",
+			data: "1\n00:00:01,000 --> 00:00:02,500\nHello\nworld\n\n2\n00:01:00,000 --> 01:00:00,000\nBye\n",
+			want: []Cue{
+				{ID: "1", Start: ms(1000), End: ms(2500), Text: "Hello\nworld"},
+				{ID: "2", Start: time.Minute, End: time.Hour, Text: "Bye"},
+			},
+		},
+		{
+			name: "BOM and CRLF",
+			data: "\ufeff1\r\n00:00:01,000 --> 00:00:02,000\r\nHello\r\n\r\n2\r\n00:00:03,000 --> 00:00:04,000\r\nBye\r\n",
+			want: []Cue{
+				{ID: "1", Start: ms(1000), End: ms(2000), Text: "Hello"},
+				{ID: "2", Start: ms(3000), End: ms(4000), Text: "Bye"},
+			},
+		},
+		{
+			name: "short milliseconds",
+			data: "1\n00:00:01,5 --> 00:00:02,05\nHello\n",
+			want: []Cue{{ID: "1", Start: ms(1500), End: ms(2050), Text: "Hello"}},
+		},
+		{
+			name: "dot separator and one-digit hours",
+			data: "1\n0:00:01.250 --> 0:00:02.750\nHello\n",
+			want: []Cue{{ID: "1", Start: ms(1250), End: ms(2750), Text: "Hello"}},
+		},
+		{
+			name: "missing cue number",
+			data: "00:00:01,000 --> 00:00:02,000\nHello\n",
+			want: []Cue{{Start: ms(1000), End: ms(2000), Text: "Hello"}},
+		},
+		{
+			name: "font tags",
+			data: "1\n00:00:01,000 --> 00:00:02,000\n<font color=\"red\"><i>Hello</i></font>\n",
+			want: []Cue{{ID: "1", Start: ms(1000), End: ms(2000), Text: "<i>Hello</i>"}},
+		},
+		{
+			name:     "invalid cue number",
+			data:     "1\n00:00:01,000 --> 00:00:02,000\nHello\n\none\n00:00:03,000 --> 00:00:04,000\nBye\n",
+			wantLine: 5,
+		},
+		{
+			name:     "missing timing line",
+			data:     "1\n00:00:01,000 --> 00:00:02,000\nHello\n\n2\n",
+			wantLine: 6,
+		},
+		{
+			name:     "malformed timing",
+			data:     "1\n00:00:01 --> 00:00:02\nHello\n",
+			wantLine: 2,
+		},
+		{
+			name:     "seconds out of range",
+			data:     "1\n00:00:61,000 --> 00:01:02,000\nHello\n",
+			wantLine: 2,
+		},
+		{
+			name:     "end equals start",
+			data:     "1\n00:00:01,000 --> 00:00:01,000\nHello\n",
+			wantLine: 2,
+		},
+		{
+			name:     "end before start",
+			data:     "1\n00:00:02,000 --> 00:00:01,000\nHello\n",
+			wantLine: 2,
+		},
+		{
+			name:     "empty",
+			data:     "\ufeff\r\n\r\n",
+			wantLine: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSRT([]byte(tt.data))
+			checkParse(t, got, err, tt.want, tt.wantLine)
+		})
+	}
+}
+
+func TestParseVTT(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		want     []Cue
+		wantLine int
+	}{
+		{
+			name: "ids, settings and optional hours",
+			data: "WEBVTT - captions\n\nintro\n00:01.000 --> 00:02.500 line:0 align:start\nHello\nworld\n\n01:00:00.000 --> 01:00:01.000\nBye\n",
+			want: []Cue{
+				{ID: "intro", Start: ms(1000), End: ms(2500), Settings: "line:0 align:start", Text: "Hello\nworld"},
+				{Start: time.Hour, End: time.Hour + time.Second, Text: "Bye"},
+			},
+		},
+		{
+			name: "BOM and CRLF",
+			data: "\ufeffWEBVTT\r\n\r\n00:01.000 --> 00:02.000\r\nHello\r\n",
+			want: []Cue{{Start: ms(1000), End: ms(2000), Text: "Hello"}},
+		},
+		{
+			name: "NOTE, STYLE and REGION blocks",
+			data: "WEBVTT\n\nNOTE made by hand\n00:09.000 --> 00:10.000 is not a cue\n\nSTYLE\n::cue { color: red }\n\nREGION\nid:top\n\n00:01.000 --> 00:02.000\nHello\n",
+			want: []Cue{{Start: ms(1000), End: ms(2000), Text: "Hello"}},
+		},
+		{
+			name: "header only",
+			data: "WEBVTT\n",
+			want: []Cue{},
+		},
+		{
+			name:     "missing header",
+			data:     "00:01.000 --> 00:02.000\nHello\n",
+			wantLine: 1,
+		},
+		{
+			name:     "header with no separator",
+			data:     "WEBVTTX\n\n00:01.000 --> 00:02.000\nHello\n",
+			wantLine: 1,
+		},
+		{
+			name:     "SRT timing",
+			data:     "WEBVTT\n\n00:00:01,000 --> 00:00:02,000\nHello\n",
+			wantLine: 3,
+		},
+		{
+			name:     "missing timing line",
+			data:     "WEBVTT\n\nintro\n",
+			wantLine: 4,
+		},
+		{
+			name:     "end before start",
+			data:     "WEBVTT\n\n00:02.000 --> 00:01.000\nHello\n",
+			wantLine: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVTT([]byte(tt.data))
+			checkParse(t, got, err, tt.want, tt.wantLine)
+		})
+	}
+}
+
+// checkParse checks a parser returned want, or if wantLine is set, a
+// ParseError at that line.
+func checkParse(t *testing.T, got []Cue, err error, want []Cue, wantLine int) {
+	t.Helper()
+	if wantLine != 0 {
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("error = %v, want a ParseError", err)
+		}
+		if parseErr.Line != wantLine {
+			t.Errorf("error at line %d, want %d: %v", parseErr.Line, wantLine, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestFormatVTT(t *testing.T) {
+	tests := []struct {
+		name string
+		cues []Cue
+		want string
+	}{
+		{
+			name: "no cues",
+			want: "WEBVTT\n",
+		},
+		{
+			name: "cues",
+			cues: []Cue{
+				{ID: "1", Start: ms(1500), End: ms(2050), Text: "Hello\nworld"},
+				{Start: time.Hour + ms(61001), End: 100 * time.Hour, Settings: "align:start"},
+			},
+			want: "WEBVTT\n\n1\n00:00:01.500 --> 00:00:02.050\nHello\nworld\n\n01:01:01.001 --> 100:00:00.000 align:start\n",
+		},
+		{
+			name: "markup kept",
+			cues: []Cue{{Start: 0, End: time.Second, Text: `<i>Hi</i> <c.yellow.bg_blue>there</c> <v Roger Bingham>Tom</v> <00:00.500>&amp;&lt;&#38;&#x26;&nbsp;`}},
+			want: "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\n<i>Hi</i> <c.yellow.bg_blue>there</c> <v Roger Bingham>Tom</v> <00:00.500>&amp;&lt;&#38;&#x26;&nbsp;\n",
+		},
+		{
+			name: "bare ampersands and angle brackets escaped",
+			cues: []Cue{{Start: 0, End: time.Second, Text: "Tom & Jerry <3 a<b & 2 > 1 <script> --> &copy &amp"}},
+			want: "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nTom &amp; Jerry &lt;3 a&lt;b &amp; 2 > 1 &lt;script> --&gt; &amp;copy &amp;amp\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(FormatVTT(tt.cues))
+			if got != tt.want {
+				t.Errorf("FormatVTT() =\n%q\nwant\n%q", got, tt.want)
+			}
+			if _, err := ParseVTT([]byte(got)); err != nil {
+				t.Errorf("output doesn't parse: %v", err)
+			}
+		})
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	text := EscapeText("Q&A: <b>bold</b> > plain")
+	want := "Q&amp;A: &lt;b&gt;bold&lt;/b&gt; &gt; plain"
+	if text != want {
+		t.Fatalf("EscapeText() = %q, want %q", text, want)
+	}
+	// Escaped text passes through FormatVTT unchanged.
+	got := string(FormatVTT([]Cue{{Start: 0, End: time.Second, Text: text}}))
+	if got != "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\n"+want+"\n" {
+		t.Errorf("FormatVTT() = %q", got)
+	}
+}
+
+func TestToVTT(t *testing.T) {
+	srt := "\ufeff1\r\n00:00:01,5 --> 00:00:02,000\r\nTom & Jerry\r\n"
+	got, err := ToVTT([]byte(srt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "WEBVTT\n\n1\n00:00:01.500 --> 00:00:02.000\nTom &amp; Jerry\n"; string(got) != want {
+		t.Errorf("ToVTT(SRT) = %q, want %q", got, want)
+	}
+
+	vtt := "WEBVTT\n\nSTYLE\n::cue { color: red }\n\n00:01.000 --> 00:02.000\nHello\n"
+	got, err = ToVTT([]byte(vtt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != vtt {
+		t.Errorf("ToVTT(WebVTT) = %q, want it unchanged", got)
+	}
+
+	if _, err := ToVTT([]byte("WEBVTT\n\nbroken\n")); err == nil {
+		t.Error("ToVTT accepted a cue without timing")
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"WEBVTT\n", FormatWebVTT},
+		{"\ufeffWEBVTT\n", FormatWebVTT},
+		{"1\n00:00:01,000 --> 00:00:02,000\n", FormatSRT},
+		{"", FormatSRT},
+	}
+	for _, tt := range tests {
+		if got := DetectFormat([]byte(tt.data)); got != tt.want {
+			t.Errorf("DetectFormat(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
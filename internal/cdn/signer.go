@@ -0,0 +1,321 @@
+// Package cdn signs CloudFront URLs and cookies so private media can be
+// served from a distribution that requires them.
+package cdn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the signed cookies CloudFront looks for.
+const (
+	CookiePolicy    = "CloudFront-Policy"
+	CookieSignature = "CloudFront-Signature"
+	CookieKeyPairID = "CloudFront-Key-Pair-Id"
+)
+
+// Policy grants access to Resource, a URL that may end in a * wildcard,
+// until Expires. NotBefore and SourceIP, a CIDR range, are optional.
+type Policy struct {
+	Resource  string
+	Expires   time.Time
+	NotBefore time.Time
+	SourceIP  string
+}
+
+// Signer signs with the private key of a CloudFront key pair or trusted
+// key group public key.
+type Signer struct {
+	keyPairID string
+	key       *rsa.PrivateKey
+}
+
+// NewSigner parses an RSA private key in PEM, either PKCS #1 as CloudFront
+// generates them or PKCS #8.
+func NewSigner(keyPairID string, privateKeyPEM []byte) (*Signer, error) {
+	if keyPairID == "" {
+		return nil, errors.New("key pair ID is required")
+	}
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM block found in private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return &Signer{keyPairID, key}, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return &Signer{keyPairID, key}, nil
+}
+
+// PublicKey returns the key signatures can be checked against.
+func (s *Signer) PublicKey() *rsa.PublicKey {
+	return &s.key.PublicKey
+}
+
+// SignURL signs rawURL with a canned policy valid until expires.
+func (s *Signer) SignURL(rawURL string, expires time.Time) (string, error) {
+	policy, err := Policy{Resource: rawURL, Expires: expires}.marshal()
+	if err != nil {
+		return "", err
+	}
+	signature, err := s.sign(policy)
+	if err != nil {
+		return "", err
+	}
+	return appendQuery(rawURL, fmt.Sprintf("Expires=%d&Signature=%s&Key-Pair-Id=%s", expires.Unix(), signature, s.keyPairID)), nil
+}
+
+// SignURLWithPolicy signs rawURL with a custom policy, which is sent
+// along in the URL.
+func (s *Signer) SignURLWithPolicy(rawURL string, p Policy) (string, error) {
+	policy, err := p.marshal()
+	if err != nil {
+		return "", err
+	}
+	signature, err := s.sign(policy)
+	if err != nil {
+		return "", err
+	}
+	return appendQuery(rawURL, fmt.Sprintf("Policy=%s&Signature=%s&Key-Pair-Id=%s", encode(policy), signature, s.keyPairID)), nil
+}
+
+// SignedCookies returns the cookies granting access under a custom
+// policy, typically with a wildcard resource covering every segment of an
+// HLS stream. The caller sets their domain and path.
+func (s *Signer) SignedCookies(p Policy) ([]*http.Cookie, error) {
+	policy, err := p.marshal()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := s.sign(policy)
+	if err != nil {
+		return nil, err
+	}
+	return []*http.Cookie{
+		{Name: CookiePolicy, Value: encode(policy)},
+		{Name: CookieSignature, Value: signature},
+		{Name: CookieKeyPairID, Value: s.keyPairID},
+	}, nil
+}
+
+func (s *Signer) sign(policy []byte) (string, error) {
+	hash := sha1.Sum(policy)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return encode(signature), nil
+}
+
+type policyDocument struct {
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Resource  string          `json:"Resource"`
+	Condition policyCondition `json:"Condition"`
+}
+
+type policyCondition struct {
+	DateLessThan    epochTime  `json:"DateLessThan"`
+	DateGreaterThan *epochTime `json:"DateGreaterThan,omitempty"`
+	IPAddress       *sourceIP  `json:"IpAddress,omitempty"`
+}
+
+type epochTime struct {
+	EpochTime int64 `json:"AWS:EpochTime"`
+}
+
+type sourceIP struct {
+	SourceIP string `json:"AWS:SourceIp"`
+}
+
+// marshal renders p as CloudFront expects it: compact JSON, with URLs left
+// unescaped, since for canned policies CloudFront rebuilds the document
+// from the request and compares signatures byte for byte.
+func (p Policy) marshal() ([]byte, error) {
+	if p.Resource == "" {
+		return nil, errors.New("policy resource is required")
+	}
+	if p.Expires.IsZero() {
+		return nil, errors.New("policy expiry is required")
+	}
+	statement := policyStatement{Resource: p.Resource}
+	statement.Condition.DateLessThan = epochTime{p.Expires.Unix()}
+	if !p.NotBefore.IsZero() {
+		statement.Condition.DateGreaterThan = &epochTime{p.NotBefore.Unix()}
+	}
+	if p.SourceIP != "" {
+		statement.Condition.IPAddress = &sourceIP{p.SourceIP}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(policyDocument{[]policyStatement{statement}}); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// CloudFront's URL-safe base64 swaps the characters that need escaping in
+// query strings for ones that don't.
+var (
+	encodeReplacer = strings.NewReplacer("+", "-", "=", "_", "/", "~")
+	decodeReplacer = strings.NewReplacer("-", "+", "_", "=", "~", "/")
+)
+
+func encode(b []byte) string {
+	return encodeReplacer.Replace(base64.StdEncoding.EncodeToString(b))
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(decodeReplacer.Replace(s))
+}
+
+func appendQuery(rawURL, query string) string {
+	if strings.Contains(rawURL, "?") {
+		return rawURL + "&" + query
+	}
+	return rawURL + "?" + query
+}
+
+// Verify checks that signature, as found in a signed URL or cookie, is a
+// valid signature of policy by the private key matching pub.
+func Verify(pub *rsa.PublicKey, policy []byte, signature string) error {
+	sig, err := decode(signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+	hash := sha1.Sum(policy)
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA1, hash[:], sig)
+}
+
+// VerifyURL checks a URL made by SignURL or SignURLWithPolicy the way
+// CloudFront would: the signature must match the policy, the policy must
+// cover the URL and it must be valid at now. It doesn't check the source
+// IP condition.
+func VerifyURL(pub *rsa.PublicKey, signedURL string, now time.Time) error {
+	u, err := url.Parse(signedURL)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	signature := query.Get("Signature")
+	if signature == "" || query.Get("Key-Pair-Id") == "" {
+		return errors.New("URL is not signed")
+	}
+	// The resource is the URL as it was before signing, so keep the
+	// remaining parameters exactly as they were written.
+	kept := []string{}
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		switch name {
+		case "Expires", "Policy", "Signature", "Key-Pair-Id":
+		default:
+			kept = append(kept, param)
+		}
+	}
+	u.RawQuery = strings.Join(kept, "&")
+	resource := u.String()
+
+	var policy []byte
+	if encoded := query.Get("Policy"); encoded != "" {
+		policy, err = decode(encoded)
+	} else if expires := query.Get("Expires"); expires != "" {
+		var seconds int64
+		seconds, err = strconv.ParseInt(expires, 10, 64)
+		if err == nil {
+			policy, err = Policy{Resource: resource, Expires: time.Unix(seconds, 0)}.marshal()
+		}
+	} else {
+		return errors.New("URL has neither Expires nor Policy")
+	}
+	if err != nil {
+		return fmt.Errorf("malformed policy: %w", err)
+	}
+	if err := Verify(pub, policy, signature); err != nil {
+		return err
+	}
+	return checkPolicy(policy, resource, now)
+}
+
+// VerifyCookies checks cookies made by SignedCookies grant access to
+// resourceURL at now.
+func VerifyCookies(pub *rsa.PublicKey, cookies []*http.Cookie, resourceURL string, now time.Time) error {
+	values := map[string]string{}
+	for _, c := range cookies {
+		values[c.Name] = c.Value
+	}
+	if values[CookieKeyPairID] == "" {
+		return errors.New("missing key pair ID cookie")
+	}
+	policy, err := decode(values[CookiePolicy])
+	if err != nil || len(policy) == 0 {
+		return errors.New("missing or malformed policy cookie")
+	}
+	if err := Verify(pub, policy, values[CookieSignature]); err != nil {
+		return err
+	}
+	return checkPolicy(policy, resourceURL, now)
+}
+
+func checkPolicy(policy []byte, resourceURL string, now time.Time) error {
+	var doc policyDocument
+	if err := json.Unmarshal(policy, &doc); err != nil || len(doc.Statement) != 1 {
+		return errors.New("malformed policy")
+	}
+	statement := doc.Statement[0]
+	if !matchResource(statement.Resource, resourceURL) {
+		return fmt.Errorf("policy doesn't cover %s", resourceURL)
+	}
+	if now.Unix() >= statement.Condition.DateLessThan.EpochTime {
+		return errors.New("policy has expired")
+	}
+	if after := statement.Condition.DateGreaterThan; after != nil && now.Unix() <= after.EpochTime {
+		return errors.New("policy is not valid yet")
+	}
+	return nil
+}
+
+// matchResource reports whether resourceURL matches pattern, in which *
+// matches any run of characters.
+func matchResource(pattern, resourceURL string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == resourceURL
+	}
+	if !strings.HasPrefix(resourceURL, parts[0]) {
+		return false
+	}
+	rest := resourceURL[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return strings.HasSuffix(rest, parts[len(parts)-1])
+}
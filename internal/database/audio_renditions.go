@@ -0,0 +1,88 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AudioRendition is an audio-only copy of a video, suitable for use as a
+// podcast enclosure.
+type AudioRendition struct {
+	ID              uuid.UUID `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	VideoID         uuid.UUID `json:"video_id"`
+	Format          string    `json:"format"`
+	MediaType       string    `json:"mime_type"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	SizeBytes       int64     `json:"size_bytes"`
+	Key             string    `json:"-"`
+	URL             string    `json:"url"`
+}
+
+func (c Client) GetAudioRenditions(videoID uuid.UUID) ([]AudioRendition, error) {
+	return getAudioRenditions(c.db, videoID)
+}
+
+func getAudioRenditions(db dbtx, videoID uuid.UUID) ([]AudioRendition, error) {
+	query := `
+	SELECT id, created_at, video_id, format, mime_type, duration_seconds, size_bytes, key, url
+	FROM audio_renditions
+	WHERE video_id = ?
+	ORDER BY format
+	`
+	rows, err := db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	renditions := []AudioRendition{}
+	for rows.Next() {
+		var a AudioRendition
+		if err := rows.Scan(
+			&a.ID,
+			&a.CreatedAt,
+			&a.VideoID,
+			&a.Format,
+			&a.MediaType,
+			&a.DurationSeconds,
+			&a.SizeBytes,
+			&a.Key,
+			&a.URL,
+		); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, a)
+	}
+	return renditions, rows.Err()
+}
+
+// replaceAudioRenditions swaps the video's audio renditions for the given
+// set. Saving them is part of PublishVideo, as they must change along
+// with the video they were extracted from.
+func replaceAudioRenditions(db dbtx, videoID uuid.UUID, renditions []AudioRendition) error {
+	if _, err := db.Exec("DELETE FROM audio_renditions WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO audio_renditions (
+		id,
+		created_at,
+		video_id,
+		format,
+		mime_type,
+		duration_seconds,
+		size_bytes,
+		key,
+		url
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	`
+	for _, a := range renditions {
+		_, err := db.Exec(query, uuid.New(), videoID, a.Format, a.MediaType, a.DurationSeconds, a.SizeBytes, a.Key, a.URL)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CaptionTrack is a WebVTT caption file for one language of a video.
+type CaptionTrack struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	VideoID   uuid.UUID `json:"video_id"`
+	Language  string    `json:"language"`
+	Label     string    `json:"label"`
+	Key       string    `json:"-"`
+	URL       string    `json:"url"`
+}
+
+type CreateCaptionTrackParams struct {
+	VideoID  uuid.UUID
+	Language string
+	Label    string
+	Key      string
+	URL      string
+}
+
+func (c Client) CreateCaptionTrack(params CreateCaptionTrackParams) (CaptionTrack, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO caption_tracks (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		language,
+		label,
+		key,
+		url
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.Language, params.Label, params.Key, params.URL)
+	if err != nil {
+		return CaptionTrack{}, err
+	}
+	return c.GetCaptionTrack(params.VideoID, params.Language)
+}
+
+func (c Client) GetCaptionTracks(videoID uuid.UUID) ([]CaptionTrack, error) {
+	query := `
+	SELECT id, created_at, updated_at, video_id, language, label, key, url
+	FROM caption_tracks
+	WHERE video_id = ?
+	ORDER BY language
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []CaptionTrack{}
+	for rows.Next() {
+		var track CaptionTrack
+		if err := rows.Scan(
+			&track.ID,
+			&track.CreatedAt,
+			&track.UpdatedAt,
+			&track.VideoID,
+			&track.Language,
+			&track.Label,
+			&track.Key,
+			&track.URL,
+		); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, rows.Err()
+}
+
+// GetCaptionTrack returns the zero CaptionTrack if the video has no track
+// for language.
+func (c Client) GetCaptionTrack(videoID uuid.UUID, language string) (CaptionTrack, error) {
+	query := `
+	SELECT id, created_at, updated_at, video_id, language, label, key, url
+	FROM caption_tracks
+	WHERE video_id = ? AND language = ?
+	`
+	var track CaptionTrack
+	err := c.db.QueryRow(query, videoID, language).Scan(
+		&track.ID,
+		&track.CreatedAt,
+		&track.UpdatedAt,
+		&track.VideoID,
+		&track.Language,
+		&track.Label,
+		&track.Key,
+		&track.URL,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return CaptionTrack{}, nil
+		}
+		return CaptionTrack{}, err
+	}
+	return track, nil
+}
+
+func (c Client) UpdateCaptionTrack(track CaptionTrack) error {
+	query := `
+	UPDATE caption_tracks
+	SET
+		label = ?,
+		key = ?,
+		url = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, track.Label, track.Key, track.URL, track.ID)
+	return err
+}
+
+func (c Client) DeleteCaptionTrack(id uuid.UUID) error {
+	query := `
+	DELETE FROM caption_tracks
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// Chapter is a titled section of a video starting at StartSeconds.
+type Chapter struct {
+	Title        string  `json:"title"`
+	StartSeconds float64 `json:"start_seconds"`
+}
+
+// GetChapters returns the video's chapters in playback order.
+func (c Client) GetChapters(videoID uuid.UUID) ([]Chapter, error) {
+	query := `
+	SELECT title, start_seconds
+	FROM chapters
+	WHERE video_id = ?
+	ORDER BY position
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []Chapter{}
+	for rows.Next() {
+		var ch Chapter
+		if err := rows.Scan(&ch.Title, &ch.StartSeconds); err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, ch)
+	}
+	return chapters, rows.Err()
+}
+
+// ReplaceChapters swaps the video's chapters for the given list, which must
+// already be in playback order.
+func (c Client) ReplaceChapters(videoID uuid.UUID, chapters []Chapter) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM chapters WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO chapters (video_id, position, title, start_seconds)
+	VALUES (?, ?, ?, ?)
+	`
+	for i, ch := range chapters {
+		if _, err := tx.Exec(query, videoID, i, ch.Title, ch.StartSeconds); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
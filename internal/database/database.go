@@ -72,6 +72,231 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	captionTrackTable := `
+	CREATE TABLE IF NOT EXISTS caption_tracks (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		language TEXT NOT NULL,
+		label TEXT NOT NULL,
+		key TEXT NOT NULL,
+		url TEXT NOT NULL,
+		UNIQUE(video_id, language),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(captionTrackTable)
+	if err != nil {
+		return err
+	}
+
+	audioRenditionTable := `
+	CREATE TABLE IF NOT EXISTS audio_renditions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		format TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		duration_seconds REAL NOT NULL,
+		size_bytes INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		url TEXT NOT NULL,
+		UNIQUE(video_id, format),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(audioRenditionTable)
+	if err != nil {
+		return err
+	}
+
+	chapterTable := `
+	CREATE TABLE IF NOT EXISTS chapters (
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		start_seconds REAL NOT NULL,
+		PRIMARY KEY(video_id, position),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(chapterTable)
+	if err != nil {
+		return err
+	}
+
+	videoVersionTable := `
+	CREATE TABLE IF NOT EXISTS video_versions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		number INTEGER NOT NULL,
+		uploaded_by TEXT NOT NULL,
+		source_key TEXT NOT NULL,
+		video_key TEXT NOT NULL,
+		video_url TEXT NOT NULL,
+		sha256 TEXT NOT NULL,
+		size_bytes INTEGER NOT NULL,
+		duration_seconds REAL NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		codec TEXT NOT NULL,
+		bit_rate INTEGER NOT NULL,
+		loudness_measured_lufs REAL,
+		loudness_target_lufs REAL,
+		UNIQUE(video_id, number),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(uploaded_by) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoVersionTable)
+	if err != nil {
+		return err
+	}
+
+	qualityReportTable := `
+	CREATE TABLE IF NOT EXISTS quality_reports (
+		video_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		source_sha256 TEXT,
+		verdict TEXT NOT NULL,
+		details TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(qualityReportTable)
+	if err != nil {
+		return err
+	}
+
+	fingerprintTable := `
+	CREATE TABLE IF NOT EXISTS video_fingerprints (
+		video_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		duration_seconds REAL NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(fingerprintTable)
+	if err != nil {
+		return err
+	}
+
+	thumbnailTable := `
+	CREATE TABLE IF NOT EXISTS thumbnails (
+		video_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		thumbnail_id TEXT NOT NULL,
+		format TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		size_bytes INTEGER NOT NULL,
+		url TEXT NOT NULL,
+		PRIMARY KEY(video_id, width, format),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(thumbnailTable)
+	if err != nil {
+		return err
+	}
+
+	columns := []struct {
+		table, name, definition string
+	}{
+		{"users", "normalize_loudness", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "loudness_target_lufs", "REAL"},
+		{"videos", "loudness_measured_lufs", "REAL"},
+		{"videos", "loudness_target_lufs", "REAL"},
+		{"users", "watermark_key", "TEXT"},
+		{"users", "watermark_position", "TEXT NOT NULL DEFAULT 'bottom-right'"},
+		{"users", "watermark_margin", "INTEGER NOT NULL DEFAULT 16"},
+		{"users", "watermark_scale", "REAL NOT NULL DEFAULT 0.15"},
+		{"users", "watermark_opacity", "REAL NOT NULL DEFAULT 0.8"},
+		{"videos", "source_key", "TEXT"},
+		{"videos", "source_sha256", "TEXT"},
+		{"videos", "parent_video_id", "TEXT REFERENCES videos(id) ON DELETE SET NULL"},
+		{"users", "audio_renditions", "TEXT NOT NULL DEFAULT ''"},
+		{"videos", "duration_seconds", "REAL"},
+		{"videos", "chapters_key", "TEXT"},
+		{"videos", "chapters_url", "TEXT"},
+		{"videos", "video_key", "TEXT"},
+		{"videos", "current_version_id", "TEXT"},
+		{"videos", "qc_verdict", "TEXT"},
+		{"videos", "duplicate_of_id", "TEXT"},
+		{"videos", "duplicate_distance", "REAL"},
+		{"videos", "thumbnail_blurhash", "TEXT"},
+		{"videos", "thumbnail_lqip", "TEXT"},
+		{"videos", "thumbnail_source_key", "TEXT"},
+		{"videos", "thumbnail_focal_x", "REAL"},
+		{"videos", "thumbnail_focal_y", "REAL"},
+		{"users", "handle", "TEXT"},
+		{"users", "display_name", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "bio", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "links", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "avatar_path", "TEXT"},
+		{"users", "avatar_url", "TEXT"},
+		// Videos from before visibility existed were all public, so they
+		// keep that; CreateVideo makes new ones unlisted.
+		{"videos", "visibility", "TEXT NOT NULL DEFAULT 'public'"},
+		{"videos", "last_version_number", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, col := range columns {
+		err = c.addColumnIfMissing(col.table, col.name, col.definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	// ALTER TABLE can't add a UNIQUE column, so handles are kept unique by
+	// an index instead. NULLs don't collide, so users without a handle are
+	// fine.
+	_, err = c.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS users_handle ON users(handle)")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addColumnIfMissing lets autoMigrate grow tables created by older versions,
+// since CREATE TABLE IF NOT EXISTS leaves existing tables untouched.
+func (c *Client) addColumnIfMissing(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
@@ -82,6 +307,27 @@ func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM thumbnails"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnails: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_fingerprints"); err != nil {
+		return fmt.Errorf("failed to reset table video_fingerprints: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM quality_reports"); err != nil {
+		return fmt.Errorf("failed to reset table quality_reports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_versions"); err != nil {
+		return fmt.Errorf("failed to reset table video_versions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM chapters"); err != nil {
+		return fmt.Errorf("failed to reset table chapters: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM audio_renditions"); err != nil {
+		return fmt.Errorf("failed to reset table audio_renditions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM caption_tracks"); err != nil {
+		return fmt.Errorf("failed to reset table caption_tracks: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// VideoFingerprint is the perceptual fingerprint of a video's latest
+// upload, hex encoded.
+type VideoFingerprint struct {
+	VideoID         uuid.UUID
+	UserID          uuid.UUID
+	Fingerprint     string
+	DurationSeconds float64
+}
+
+// SaveFingerprint replaces the video's fingerprint.
+func (c Client) SaveFingerprint(fp VideoFingerprint) error {
+	query := `
+	INSERT OR REPLACE INTO video_fingerprints (video_id, created_at, user_id, fingerprint, duration_seconds)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, fp.VideoID, fp.UserID, fp.Fingerprint, fp.DurationSeconds)
+	return err
+}
+
+// DeleteFingerprint removes the video's fingerprint, so it is no longer
+// compared with other uploads.
+func (c Client) DeleteFingerprint(videoID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM video_fingerprints WHERE video_id = ?", videoID)
+	return err
+}
+
+// GetFingerprintCandidates returns the fingerprints of other videos whose
+// duration is between minSeconds and maxSeconds, limited to userID's
+// videos unless userID is uuid.Nil.
+func (c Client) GetFingerprintCandidates(videoID, userID uuid.UUID, minSeconds, maxSeconds float64) ([]VideoFingerprint, error) {
+	query := `
+	SELECT video_id, user_id, fingerprint, duration_seconds
+	FROM video_fingerprints
+	WHERE video_id != ?
+		AND duration_seconds BETWEEN ? AND ?
+	`
+	args := []any{videoID, minSeconds, maxSeconds}
+	if userID != uuid.Nil {
+		query += "AND user_id = ?"
+		args = append(args, userID)
+	}
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fingerprints := []VideoFingerprint{}
+	for rows.Next() {
+		var fp VideoFingerprint
+		if err := rows.Scan(&fp.VideoID, &fp.UserID, &fp.Fingerprint, &fp.DurationSeconds); err != nil {
+			return nil, err
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+	return fingerprints, rows.Err()
+}
+
+// GetDuplicateVideos returns every video flagged as a near-duplicate of
+// another, newest first.
+func (c Client) GetDuplicateVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE duplicate_of_id IS NOT NULL
+	ORDER BY updated_at DESC
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ProcessingSettings are a user's defaults for the optional steps of the
+// video pipeline. Individual uploads may override them.
+type ProcessingSettings struct {
+	NormalizeLoudness  bool              `json:"normalize_loudness"`
+	LoudnessTargetLUFS *float64          `json:"loudness_target_lufs"`
+	Watermark          WatermarkSettings `json:"watermark"`
+	// AudioRenditions lists the audio-only formats to extract, e.g. "m4a"
+	// and "mp3".
+	AudioRenditions []string `json:"audio_renditions"`
+}
+
+// WatermarkSettings control how a user's watermark image is overlaid.
+// Scale is the watermark width as a fraction of the video width; Margin is
+// in pixels.
+type WatermarkSettings struct {
+	Key *string `json:"-"`
+	// HasImage reports whether a watermark image has been uploaded; the
+	// watermark is applied whenever one has.
+	HasImage bool    `json:"has_image"`
+	Position string  `json:"position"`
+	Margin   int     `json:"margin"`
+	Scale    float64 `json:"scale"`
+	Opacity  float64 `json:"opacity"`
+}
+
+func (c Client) GetProcessingSettings(userID uuid.UUID) (ProcessingSettings, error) {
+	query := `
+		SELECT
+			normalize_loudness,
+			loudness_target_lufs,
+			watermark_key,
+			watermark_position,
+			watermark_margin,
+			watermark_scale,
+			watermark_opacity,
+			audio_renditions
+		FROM users
+		WHERE id = ?
+	`
+	var settings ProcessingSettings
+	var audioRenditions string
+	err := c.db.QueryRow(query, userID.String()).Scan(
+		&settings.NormalizeLoudness,
+		&settings.LoudnessTargetLUFS,
+		&settings.Watermark.Key,
+		&settings.Watermark.Position,
+		&settings.Watermark.Margin,
+		&settings.Watermark.Scale,
+		&settings.Watermark.Opacity,
+		&audioRenditions,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ProcessingSettings{}, nil
+		}
+		return ProcessingSettings{}, err
+	}
+	settings.Watermark.HasImage = settings.Watermark.Key != nil
+	settings.AudioRenditions = []string{}
+	if audioRenditions != "" {
+		settings.AudioRenditions = strings.Split(audioRenditions, ",")
+	}
+	return settings, nil
+}
+
+// UpdateProcessingSettings saves everything except the watermark image,
+// which is managed with SetWatermarkKey.
+func (c Client) UpdateProcessingSettings(userID uuid.UUID, settings ProcessingSettings) error {
+	query := `
+		UPDATE users
+		SET
+			normalize_loudness = ?,
+			loudness_target_lufs = ?,
+			watermark_position = ?,
+			watermark_margin = ?,
+			watermark_scale = ?,
+			watermark_opacity = ?,
+			audio_renditions = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(
+		query,
+		settings.NormalizeLoudness,
+		settings.LoudnessTargetLUFS,
+		settings.Watermark.Position,
+		settings.Watermark.Margin,
+		settings.Watermark.Scale,
+		settings.Watermark.Opacity,
+		strings.Join(settings.AudioRenditions, ","),
+		userID.String(),
+	)
+	return err
+}
+
+// SetWatermarkKey records the storage key of the user's watermark image. A
+// nil key removes the watermark.
+func (c Client) SetWatermarkKey(userID uuid.UUID, key *string) error {
+	query := `
+		UPDATE users
+		SET watermark_key = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, key, userID.String())
+	return err
+}
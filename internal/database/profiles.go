@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrHandleTaken is returned by UpdateProfile when another user already
+// has the handle.
+var ErrHandleTaken = errors.New("handle is taken")
+
+// Profile is the public face of a user: everything here may be shown to
+// anyone, so it never includes the email address or password hash.
+type Profile struct {
+	UserID      uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Handle      *string   `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	Links       []string  `json:"links"`
+	AvatarURL   *string   `json:"avatar_url"`
+	// AvatarPath is where the avatar is stored, relative to the assets
+	// directory.
+	AvatarPath *string `json:"-"`
+}
+
+const profileColumns = `
+		id,
+		created_at,
+		handle,
+		display_name,
+		bio,
+		links,
+		avatar_path,
+		avatar_url`
+
+func scanProfile(row rowScanner) (Profile, error) {
+	var profile Profile
+	var id, links string
+	err := row.Scan(
+		&id,
+		&profile.CreatedAt,
+		&profile.Handle,
+		&profile.DisplayName,
+		&profile.Bio,
+		&links,
+		&profile.AvatarPath,
+		&profile.AvatarURL,
+	)
+	if err != nil {
+		return Profile{}, err
+	}
+	profile.UserID, err = uuid.Parse(id)
+	if err != nil {
+		return Profile{}, err
+	}
+	// Links are stored one per line; URLs can't contain newlines.
+	profile.Links = []string{}
+	if links != "" {
+		profile.Links = strings.Split(links, "\n")
+	}
+	return profile, nil
+}
+
+func (c Client) GetProfile(userID uuid.UUID) (Profile, error) {
+	query := `
+	SELECT` + profileColumns + `
+	FROM users
+	WHERE id = ?
+	`
+	profile, err := scanProfile(c.db.QueryRow(query, userID.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Profile{}, nil
+		}
+		return Profile{}, err
+	}
+	return profile, nil
+}
+
+// GetProfileByHandle looks a profile up by its handle, which is matched
+// exactly; handles are stored lowercase.
+func (c Client) GetProfileByHandle(handle string) (Profile, error) {
+	query := `
+	SELECT` + profileColumns + `
+	FROM users
+	WHERE handle = ?
+	`
+	profile, err := scanProfile(c.db.QueryRow(query, handle))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Profile{}, nil
+		}
+		return Profile{}, err
+	}
+	return profile, nil
+}
+
+// UpdateProfile saves every field of profile, including the avatar.
+func (c Client) UpdateProfile(profile Profile) error {
+	query := `
+		UPDATE users
+		SET
+			handle = ?,
+			display_name = ?,
+			bio = ?,
+			links = ?,
+			avatar_path = ?,
+			avatar_url = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(
+		query,
+		profile.Handle,
+		profile.DisplayName,
+		profile.Bio,
+		strings.Join(profile.Links, "\n"),
+		profile.AvatarPath,
+		profile.AvatarURL,
+		profile.UserID.String(),
+	)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrHandleTaken
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QualityReport is the outcome of the automated quality check of a video's
+// latest upload. Details holds the detected intervals and issues as JSON.
+type QualityReport struct {
+	VideoID      uuid.UUID       `json:"video_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	SourceSHA256 *string         `json:"source_sha256"`
+	Verdict      string          `json:"verdict"`
+	Details      json.RawMessage `json:"details"`
+}
+
+func (c Client) GetQualityReport(videoID uuid.UUID) (QualityReport, error) {
+	query := `
+	SELECT video_id, created_at, source_sha256, verdict, details
+	FROM quality_reports
+	WHERE video_id = ?
+	`
+	var report QualityReport
+	var details string
+	err := c.db.QueryRow(query, videoID).Scan(
+		&report.VideoID,
+		&report.CreatedAt,
+		&report.SourceSHA256,
+		&report.Verdict,
+		&details,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return QualityReport{}, nil
+		}
+		return QualityReport{}, err
+	}
+	report.Details = json.RawMessage(details)
+	return report, nil
+}
+
+// SaveQualityReport replaces the video's quality report.
+func (c Client) SaveQualityReport(report QualityReport) error {
+	query := `
+	INSERT OR REPLACE INTO quality_reports (video_id, created_at, source_sha256, verdict, details)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, report.VideoID, report.SourceSHA256, report.Verdict, string(report.Details))
+	return err
+}
+
+func (c Client) DeleteQualityReport(videoID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM quality_reports WHERE video_id = ?", videoID)
+	return err
+}
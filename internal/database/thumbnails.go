@@ -0,0 +1,106 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// Thumbnail is one size and format of a video's thumbnail. All renditions
+// of the same image share a ThumbnailID.
+type Thumbnail struct {
+	ThumbnailID uuid.UUID `json:"thumbnail_id"`
+	Format      string    `json:"format"`
+	MediaType   string    `json:"mime_type"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	SizeBytes   int64     `json:"size_bytes"`
+	URL         string    `json:"url"`
+}
+
+// GetThumbnails returns the renditions of the video's thumbnail, smallest
+// first.
+func (c Client) GetThumbnails(videoID uuid.UUID) ([]Thumbnail, error) {
+	query := `
+	SELECT thumbnail_id, format, mime_type, width, height, size_bytes, url
+	FROM thumbnails
+	WHERE video_id = ?
+	ORDER BY width, format
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	thumbnails := []Thumbnail{}
+	for rows.Next() {
+		var t Thumbnail
+		if err := rows.Scan(&t.ThumbnailID, &t.Format, &t.MediaType, &t.Width, &t.Height, &t.SizeBytes, &t.URL); err != nil {
+			return nil, err
+		}
+		thumbnails = append(thumbnails, t)
+	}
+	return thumbnails, rows.Err()
+}
+
+// GetThumbnailVisibility returns the visibility of the video a thumbnail
+// belongs to, or "" if no video has it.
+func (c Client) GetThumbnailVisibility(thumbnailID uuid.UUID) (string, error) {
+	query := `
+	SELECT videos.visibility
+	FROM thumbnails
+	JOIN videos ON videos.id = thumbnails.video_id
+	WHERE thumbnails.thumbnail_id = ?
+	LIMIT 1
+	`
+	var visibility string
+	err := c.db.QueryRow(query, thumbnailID).Scan(&visibility)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return visibility, err
+}
+
+// ReplaceThumbnails swaps the video's thumbnail renditions for the given
+// set and returns the ones it removed so their files can be deleted.
+func (c Client) ReplaceThumbnails(videoID uuid.UUID, thumbnails []Thumbnail) ([]Thumbnail, error) {
+	previous, err := c.GetThumbnails(videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM thumbnails WHERE video_id = ?", videoID); err != nil {
+		return nil, err
+	}
+	query := `
+	INSERT INTO thumbnails (
+		video_id,
+		created_at,
+		thumbnail_id,
+		format,
+		mime_type,
+		width,
+		height,
+		size_bytes,
+		url
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	`
+	for _, t := range thumbnails {
+		_, err := tx.Exec(query, videoID, t.ThumbnailID, t.Format, t.MediaType, t.Width, t.Height, t.SizeBytes, t.URL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
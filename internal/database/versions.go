@@ -0,0 +1,178 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoVersion records one successful upload of a video: the stored
+// original, the processed file published from it, and what ffprobe saw.
+type VideoVersion struct {
+	ID                   uuid.UUID `json:"id"`
+	CreatedAt            time.Time `json:"created_at"`
+	VideoID              uuid.UUID `json:"video_id"`
+	Number               int       `json:"number"`
+	UploadedBy           uuid.UUID `json:"uploaded_by"`
+	SourceKey            string    `json:"-"`
+	VideoKey             string    `json:"-"`
+	VideoURL             string    `json:"video_url"`
+	SHA256               string    `json:"sha256"`
+	SizeBytes            int64     `json:"size_bytes"`
+	DurationSeconds      float64   `json:"duration_seconds"`
+	Width                int       `json:"width"`
+	Height               int       `json:"height"`
+	Codec                string    `json:"codec"`
+	BitRate              int64     `json:"bit_rate"`
+	LoudnessMeasuredLUFS *float64  `json:"loudness_measured_lufs"`
+	LoudnessTargetLUFS   *float64  `json:"loudness_target_lufs"`
+}
+
+const versionColumns = `
+		id,
+		created_at,
+		video_id,
+		number,
+		uploaded_by,
+		source_key,
+		video_key,
+		video_url,
+		sha256,
+		size_bytes,
+		duration_seconds,
+		width,
+		height,
+		codec,
+		bit_rate,
+		loudness_measured_lufs,
+		loudness_target_lufs`
+
+func scanVersion(row rowScanner) (VideoVersion, error) {
+	var v VideoVersion
+	err := row.Scan(
+		&v.ID,
+		&v.CreatedAt,
+		&v.VideoID,
+		&v.Number,
+		&v.UploadedBy,
+		&v.SourceKey,
+		&v.VideoKey,
+		&v.VideoURL,
+		&v.SHA256,
+		&v.SizeBytes,
+		&v.DurationSeconds,
+		&v.Width,
+		&v.Height,
+		&v.Codec,
+		&v.BitRate,
+		&v.LoudnessMeasuredLUFS,
+		&v.LoudnessTargetLUFS,
+	)
+	return v, err
+}
+
+// ReserveVideoVersionNumber claims the number of the video's next version,
+// so its files can be stored under it before it is recorded. Concurrent
+// uploads each get a number of their own; those of uploads that fail are
+// not reused.
+func (c Client) ReserveVideoVersionNumber(videoID uuid.UUID) (int, error) {
+	query := `
+	UPDATE videos
+	SET last_version_number = MAX(
+		last_version_number,
+		(SELECT COALESCE(MAX(number), 0) FROM video_versions WHERE video_id = videos.id)
+	) + 1
+	WHERE id = ?
+	RETURNING last_version_number
+	`
+	var number int
+	err := c.db.QueryRow(query, videoID).Scan(&number)
+	if err != nil {
+		return 0, err
+	}
+	return number, nil
+}
+
+// saveVideoVersion records v, which has its ID set by the caller. If v
+// was already recorded, only its processed file is updated, as happens
+// when its original is processed again. Saving a version is part of
+// PublishVideo, as it must change along with the video.
+func saveVideoVersion(db dbtx, v VideoVersion) error {
+	query := `
+	INSERT INTO video_versions (` + versionColumns + `
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(id) DO UPDATE SET
+		video_key = excluded.video_key,
+		video_url = excluded.video_url,
+		loudness_measured_lufs = excluded.loudness_measured_lufs,
+		loudness_target_lufs = excluded.loudness_target_lufs
+	`
+	_, err := db.Exec(
+		query,
+		v.ID,
+		v.VideoID,
+		v.Number,
+		v.UploadedBy,
+		v.SourceKey,
+		v.VideoKey,
+		v.VideoURL,
+		v.SHA256,
+		v.SizeBytes,
+		v.DurationSeconds,
+		v.Width,
+		v.Height,
+		v.Codec,
+		v.BitRate,
+		v.LoudnessMeasuredLUFS,
+		v.LoudnessTargetLUFS,
+	)
+	return err
+}
+
+// GetVideoVersions returns the video's versions, newest first.
+func (c Client) GetVideoVersions(videoID uuid.UUID) ([]VideoVersion, error) {
+	query := `
+	SELECT` + versionColumns + `
+	FROM video_versions
+	WHERE video_id = ?
+	ORDER BY number DESC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []VideoVersion{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+func (c Client) GetVideoVersion(videoID uuid.UUID, number int) (VideoVersion, error) {
+	query := `
+	SELECT` + versionColumns + `
+	FROM video_versions
+	WHERE video_id = ? AND number = ?
+	`
+	v, err := scanVersion(c.db.QueryRow(query, videoID, number))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoVersion{}, nil
+		}
+		return VideoVersion{}, err
+	}
+	return v, nil
+}
+
+func (c Client) DeleteVideoVersion(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM video_versions WHERE id = ?", id)
+	return err
+}
@@ -9,11 +9,51 @@ import (
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID                   uuid.UUID   `json:"id"`
+	CreatedAt            time.Time   `json:"created_at"`
+	UpdatedAt            time.Time   `json:"updated_at"`
+	ThumbnailURL         *string     `json:"thumbnail_url"`
+	Thumbnails           []Thumbnail `json:"thumbnails"`
+	VideoURL             *string     `json:"video_url"`
+	LoudnessMeasuredLUFS *float64    `json:"loudness_measured_lufs"`
+	LoudnessTargetLUFS   *float64    `json:"loudness_target_lufs"`
+	DurationSeconds      *float64    `json:"duration_seconds"`
+	ChaptersURL          *string     `json:"chapters_url"`
+	// ThumbnailBlurHash and ThumbnailLQIP are placeholders to show while
+	// the thumbnail loads; the LQIP is a data: URI.
+	ThumbnailBlurHash *string `json:"thumbnail_blurhash"`
+	ThumbnailLQIP     *string `json:"thumbnail_lqip"`
+	// ThumbnailFocalX and ThumbnailFocalY are the focal point, relative to
+	// the original image, the thumbnail was cropped around; nil if it was
+	// cropped to the most detailed region instead.
+	ThumbnailFocalX *float64 `json:"thumbnail_focal_x"`
+	ThumbnailFocalY *float64 `json:"thumbnail_focal_y"`
+	// ThumbnailSourceKey is the storage key of the sanitized, uncropped
+	// thumbnail image, kept so it can be cropped again.
+	ThumbnailSourceKey *string `json:"-"`
+	// SourceSHA256 is the hex SHA-256 of the upload as received.
+	SourceSHA256 *string `json:"source_sha256"`
+	// QCVerdict is the overall result of the latest quality check: pass,
+	// warn or fail.
+	QCVerdict *string `json:"qc_verdict"`
+	// DuplicateOfID is set when the latest upload looks like a re-encode of
+	// another video, DuplicateDistance bits apart per sampled frame.
+	DuplicateOfID     *uuid.UUID `json:"duplicate_of_id"`
+	DuplicateDistance *float64   `json:"duplicate_distance"`
+	// ParentVideoID is set on clips to the video they were cut from.
+	ParentVideoID   *uuid.UUID       `json:"parent_video_id"`
+	Captions        []CaptionTrack   `json:"captions"`
+	AudioRenditions []AudioRendition `json:"audio_renditions"`
+	Chapters        []Chapter        `json:"chapters"`
+	// ChaptersKey is the storage key of the WebVTT chapters track.
+	ChaptersKey *string `json:"-"`
+	// SourceKey is the storage key of the upload as received, before any
+	// processing, kept so the video can be processed again later.
+	SourceKey *string `json:"-"`
+	// VideoKey is the storage key of the processed file at VideoURL.
+	VideoKey *string `json:"-"`
+	// CurrentVersionID is the upload VideoURL was published from.
+	CurrentVersionID *uuid.UUID `json:"current_version_id"`
 	CreateVideoParams
 }
 
@@ -21,19 +61,97 @@ type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	// Visibility is one of VisibilityPublic, VisibilityUnlisted or
+	// VisibilityPrivate.
+	Visibility string `json:"visibility"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// Video visibilities. Public videos are listed for everyone; unlisted ones
+// can be watched by anyone with the link; private ones only by their
+// owner.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
+		thumbnail_blurhash,
+		thumbnail_lqip,
+		thumbnail_focal_x,
+		thumbnail_focal_y,
+		thumbnail_source_key,
 		video_url,
-		user_id
+		loudness_measured_lufs,
+		loudness_target_lufs,
+		duration_seconds,
+		chapters_key,
+		chapters_url,
+		source_key,
+		source_sha256,
+		video_key,
+		current_version_id,
+		qc_verdict,
+		duplicate_of_id,
+		duplicate_distance,
+		parent_video_id,
+		visibility,
+		user_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx, so the same query can run
+// on its own or as part of a transaction.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailBlurHash,
+		&video.ThumbnailLQIP,
+		&video.ThumbnailFocalX,
+		&video.ThumbnailFocalY,
+		&video.ThumbnailSourceKey,
+		&video.VideoURL,
+		&video.LoudnessMeasuredLUFS,
+		&video.LoudnessTargetLUFS,
+		&video.DurationSeconds,
+		&video.ChaptersKey,
+		&video.ChaptersURL,
+		&video.SourceKey,
+		&video.SourceSHA256,
+		&video.VideoKey,
+		&video.CurrentVersionID,
+		&video.QCVerdict,
+		&video.DuplicateOfID,
+		&video.DuplicateDistance,
+		&video.ParentVideoID,
+		&video.Visibility,
+		&video.UserID,
+	)
+	return video, err
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
 	ORDER BY created_at DESC
@@ -47,25 +165,69 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	rows.Close()
+
+	for i := range videos {
+		if err := c.loadVideoRelations(&videos[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return videos, nil
+}
+
+// GetPublicVideos returns a page of public videos that have been
+// published, newest first.
+func (c Client) GetPublicVideos(limit, offset int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE visibility = ? AND video_key IS NOT NULL
+	ORDER BY created_at DESC, id
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := c.db.Query(query, VisibilityPublic, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	rows.Close()
+
+	for i := range videos {
+		if err := c.loadVideoRelations(&videos[i]); err != nil {
+			return nil, err
+		}
+	}
 
 	return videos, nil
 }
 
+// CountRestrictedVideos returns how many published videos aren't public.
+func (c Client) CountRestrictedVideos() (int, error) {
+	var count int
+	err := c.db.QueryRow(`
+	SELECT COUNT(*) FROM videos
+	WHERE visibility != ? AND video_key IS NOT NULL
+	`, VisibilityPublic).Scan(&count)
+	return count, err
+}
+
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	id := uuid.New()
 	query := `
@@ -75,10 +237,14 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
+		visibility,
 		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	if params.Visibility == "" {
+		params.Visibility = VisibilityUnlisted
+	}
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.Visibility, params.UserID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +254,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -118,27 +267,123 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		return Video{}, err
 	}
 
+	if err := c.loadVideoRelations(&video); err != nil {
+		return Video{}, err
+	}
 	return video, nil
 }
 
+// loadVideoRelations fills the fields of video that live in other tables.
+func (c Client) loadVideoRelations(video *Video) error {
+	var err error
+	video.Captions, err = c.GetCaptionTracks(video.ID)
+	if err != nil {
+		return err
+	}
+	video.AudioRenditions, err = c.GetAudioRenditions(video.ID)
+	if err != nil {
+		return err
+	}
+	video.Chapters, err = c.GetChapters(video.ID)
+	if err != nil {
+		return err
+	}
+	video.Thumbnails, err = c.GetThumbnails(video.ID)
+	return err
+}
+
 func (c Client) UpdateVideo(video Video) error {
+	return updateVideo(c.db, video)
+}
+
+// PublishVideo saves video after a pipeline run together with the audio
+// renditions it published, which replace the ones it had, and the version
+// it published, if not nil, in one transaction. It returns the saved
+// renditions.
+func (c Client) PublishVideo(video Video, version *VideoVersion) ([]AudioRendition, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if version != nil {
+		if err := saveVideoVersion(tx, *version); err != nil {
+			return nil, err
+		}
+	}
+	if err := updateVideo(tx, video); err != nil {
+		return nil, err
+	}
+	if err := replaceAudioRenditions(tx, video.ID, video.AudioRenditions); err != nil {
+		return nil, err
+	}
+	renditions, err := getAudioRenditions(tx, video.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return renditions, nil
+}
+
+func updateVideo(db dbtx, video Video) error {
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_blurhash = ?,
+		thumbnail_lqip = ?,
+		thumbnail_focal_x = ?,
+		thumbnail_focal_y = ?,
+		thumbnail_source_key = ?,
 		video_url = ?,
+		loudness_measured_lufs = ?,
+		loudness_target_lufs = ?,
+		duration_seconds = ?,
+		chapters_key = ?,
+		chapters_url = ?,
+		source_key = ?,
+		source_sha256 = ?,
+		video_key = ?,
+		current_version_id = ?,
+		qc_verdict = ?,
+		duplicate_of_id = ?,
+		duplicate_distance = ?,
+		parent_video_id = ?,
+		visibility = ?,
 		user_id = ?
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	_, err := db.Exec(
 		query,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		video.ThumbnailBlurHash,
+		video.ThumbnailLQIP,
+		video.ThumbnailFocalX,
+		video.ThumbnailFocalY,
+		video.ThumbnailSourceKey,
 		&video.VideoURL,
+		video.LoudnessMeasuredLUFS,
+		video.LoudnessTargetLUFS,
+		video.DurationSeconds,
+		video.ChaptersKey,
+		video.ChaptersURL,
+		video.SourceKey,
+		video.SourceSHA256,
+		video.VideoKey,
+		video.CurrentVersionID,
+		video.QCVerdict,
+		video.DuplicateOfID,
+		video.DuplicateDistance,
+		video.ParentVideoID,
+		video.Visibility,
 		video.UserID,
 		video.ID,
 	)
@@ -146,10 +391,19 @@ func (c Client) UpdateVideo(video Video) error {
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	for _, table := range []string{"caption_tracks", "audio_renditions", "chapters", "video_versions", "quality_reports", "video_fingerprints", "thumbnails"} {
+		if _, err := c.db.Exec("DELETE FROM "+table+" WHERE video_id = ?", id); err != nil {
+			return err
+		}
+	}
+	_, err := c.db.Exec("UPDATE videos SET duplicate_of_id = NULL, duplicate_distance = NULL WHERE duplicate_of_id = ?", id)
+	if err != nil {
+		return err
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err = c.db.Exec(query, id)
 	return err
 }
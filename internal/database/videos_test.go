@@ -0,0 +1,73 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// TestVisibilityMigration opens a database made before videos had a
+// visibility and checks its videos stay public.
+func TestVisibilityMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tubely.db")
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldID := uuid.New()
+	_, err = old.Exec(`
+	CREATE TABLE videos (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT,
+		thumbnail_url TEXT,
+		video_url TEXT TEXT,
+		user_id INTEGER
+	);
+	INSERT INTO videos (id, title, description, video_url) VALUES (?, 'Old video', '', 'https://example.com/old.mp4');
+	`, oldID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	c, err := NewClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := c.GetVideo(oldID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Visibility != VisibilityPublic {
+		t.Errorf("existing video is %q, want %q", video.Visibility, VisibilityPublic)
+	}
+
+	created, err := c.CreateVideo(CreateVideoParams{Title: "New video", UserID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Visibility != VisibilityUnlisted {
+		t.Errorf("new video is %q, want %q", created.Visibility, VisibilityUnlisted)
+	}
+
+	// Only published videos need media URLs.
+	key := "landscape/new.mp4"
+	for _, want := range []int{0, 1} {
+		count, err := c.CountRestrictedVideos()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Errorf("CountRestrictedVideos() = %d, want %d", count, want)
+		}
+		created.VideoKey = &key
+		if err := c.UpdateVideo(created); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// FocalPoint is the part of an image that matters most, in coordinates
+// relative to its size: (0, 0) is the top left and (1, 1) the bottom
+// right.
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+func (p FocalPoint) Validate() error {
+	if p.X < 0 || p.X > 1 || p.Y < 0 || p.Y > 1 || math.IsNaN(p.X) || math.IsNaN(p.Y) {
+		return fmt.Errorf("focal point coordinates must be between 0 and 1")
+	}
+	return nil
+}
+
+// entropyPositions is how many window positions EntropyCrop compares.
+const entropyPositions = 24
+
+// CropToAspect crops img to the largest aspectW:aspectH rectangle it
+// contains. The rectangle is centred on focal when given, as far as the
+// image edges allow; otherwise it is placed where the image has the most
+// detail, as measured by EntropyCrop.
+func CropToAspect(img image.Image, aspectW, aspectH int, focal *FocalPoint) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	cropW, cropH := w, h
+	if w*aspectH > h*aspectW {
+		cropW = max(1, h*aspectW/aspectH)
+	} else {
+		cropH = max(1, w*aspectH/aspectW)
+	}
+	if cropW == w && cropH == h {
+		return img
+	}
+
+	var offset image.Point
+	if focal != nil {
+		offset = image.Point{
+			X: clamp(int(focal.X*float64(w))-cropW/2, 0, w-cropW),
+			Y: clamp(int(focal.Y*float64(h))-cropH/2, 0, h-cropH),
+		}
+	} else {
+		offset = EntropyCrop(img, cropW, cropH)
+	}
+
+	rect := image.Rect(0, 0, cropW, cropH).Add(bounds.Min).Add(offset)
+	dst := image.NewRGBA(image.Rect(0, 0, cropW, cropH))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+// EntropyCrop returns the offset of the cropW by cropH window, slid along
+// whichever axis has room, whose luminance histogram has the highest
+// Shannon entropy: a rough measure of how much is going on there.
+func EntropyCrop(img image.Image, cropW, cropH int) image.Point {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Work on a small copy; entropy barely changes with scale.
+	small := Resize(img, 128)
+	scale := float64(small.Bounds().Dx()) / float64(w)
+	smallW := max(1, int(float64(cropW)*scale))
+	smallH := max(1, int(float64(cropH)*scale))
+	freeX := small.Bounds().Dx() - smallW
+	freeY := small.Bounds().Dy() - smallH
+
+	best, bestEntropy := image.Point{}, -1.0
+	for i := 0; i <= entropyPositions; i++ {
+		frac := float64(i) / entropyPositions
+		p := image.Point{X: int(frac * float64(max(freeX, 0))), Y: int(frac * float64(max(freeY, 0)))}
+		e := entropy(small, image.Rect(p.X, p.Y, p.X+smallW, p.Y+smallH))
+		if e > bestEntropy {
+			best, bestEntropy = p, e
+		}
+	}
+	return image.Point{
+		X: clamp(int(float64(best.X)/scale), 0, w-cropW),
+		Y: clamp(int(float64(best.Y)/scale), 0, h-cropH),
+	}
+}
+
+func entropy(img *image.RGBA, rect image.Rectangle) float64 {
+	var histogram [256]int
+	n := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			luma := (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+			histogram[luma]++
+			n++
+		}
+	}
+	var e float64
+	for _, count := range histogram {
+		if count > 0 {
+			p := float64(count) / float64(n)
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
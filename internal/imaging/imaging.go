@@ -0,0 +1,162 @@
+// Package imaging verifies user-supplied images and reduces them to their
+// pixels, so nothing but image data reaches storage.
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// Codes reported in Error.Code.
+const (
+	CodeUnsupportedImage = "unsupported_image"
+	CodeCorruptImage     = "corrupt_image"
+	CodeImageTooLarge    = "image_too_large"
+)
+
+// Media types Sniff recognizes.
+const (
+	TypeJPEG = "image/jpeg"
+	TypePNG  = "image/png"
+	TypeGIF  = "image/gif"
+	TypeWebP = "image/webp"
+	TypeAVIF = "image/avif"
+	TypeHEIC = "image/heic"
+)
+
+// SupportedTypes names the accepted formats for error messages.
+const SupportedTypes = "JPEG, PNG, GIF, WebP, AVIF and HEIC"
+
+// Limits bound what Decode accepts. Zero values disable a check.
+type Limits struct {
+	MaxBytes  int64
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+}
+
+// Error explains why an image was rejected. Code is stable and meant for
+// clients; Message is for people.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// heicBrands are the ISO BMFF brands of HEIF images with HEVC content.
+var heicBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true,
+	"heim": true, "heis": true, "mif1": true, "msf1": true,
+}
+
+// Sniff identifies an image from its leading bytes, ignoring whatever
+// type the client claimed. It returns "" for anything unrecognized.
+func Sniff(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\xff\xd8\xff")):
+		return TypeJPEG
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return TypePNG
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return TypeGIF
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return TypeWebP
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		return sniffFtyp(head)
+	}
+	return ""
+}
+
+// sniffFtyp tells AVIF from HEIC by the major and compatible brands of an
+// ISO BMFF ftyp box. Generic HEIF brands count as HEIC unless an AVIF
+// brand is also listed.
+func sniffFtyp(head []byte) string {
+	size := int(binary.BigEndian.Uint32(head[:4]))
+	size = min(size, len(head))
+	brands := []string{string(head[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(head[i:i+4]))
+	}
+	mediaType := ""
+	for _, brand := range brands {
+		switch {
+		case brand == "avif" || brand == "avis":
+			return TypeAVIF
+		case heicBrands[brand]:
+			mediaType = TypeHEIC
+		}
+	}
+	return mediaType
+}
+
+// Native reports whether Go can decode images of mediaType itself. Others
+// must be converted, e.g. to PNG with ffmpeg, before DecodeBytes.
+func Native(mediaType string) bool {
+	return mediaType == TypeJPEG || mediaType == TypePNG || mediaType == TypeGIF
+}
+
+// Read reads an image from r, enforcing limits.MaxBytes, and identifies
+// its real type.
+func Read(r io.Reader, limits Limits) ([]byte, string, error) {
+	if limits.MaxBytes > 0 {
+		r = io.LimitReader(r, limits.MaxBytes+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if limits.MaxBytes > 0 && int64(len(data)) > limits.MaxBytes {
+		return nil, "", &Error{CodeImageTooLarge, fmt.Sprintf("Image is larger than %d bytes", limits.MaxBytes)}
+	}
+
+	mediaType := Sniff(data)
+	if mediaType == "" {
+		return nil, "", &Error{CodeUnsupportedImage, "File is not a supported image; use " + SupportedTypes}
+	}
+	return data, mediaType, nil
+}
+
+// DecodeBytes checks the size of a natively decodable image against limits
+// before decoding it, then decodes it in full. Only the pixels are
+// returned: EXIF, XMP and any other metadata are left behind. Animated
+// GIFs decode to their first frame.
+func DecodeBytes(data []byte, limits Limits) (image.Image, error) {
+	// Check the header's dimensions first so an oversized image is
+	// rejected without allocating its pixels.
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, &Error{CodeCorruptImage, "Image header couldn't be read; supported types are " + SupportedTypes}
+	}
+	if err := CheckSize(cfg.Width, cfg.Height, limits); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &Error{CodeCorruptImage, "Image couldn't be decoded; supported types are " + SupportedTypes}
+	}
+	return img, nil
+}
+
+// CheckSize checks image dimensions against limits.
+func CheckSize(width, height int, limits Limits) error {
+	if width <= 0 || height <= 0 {
+		return &Error{CodeCorruptImage, "Image has no pixels"}
+	}
+	if (limits.MaxWidth > 0 && width > limits.MaxWidth) || (limits.MaxHeight > 0 && height > limits.MaxHeight) {
+		return &Error{CodeImageTooLarge, fmt.Sprintf("Image is %dx%d; the maximum is %dx%d", width, height, limits.MaxWidth, limits.MaxHeight)}
+	}
+	if limits.MaxPixels > 0 && int64(width)*int64(height) > limits.MaxPixels {
+		return &Error{CodeImageTooLarge, fmt.Sprintf("Image has %d pixels; the maximum is %d", int64(width)*int64(height), limits.MaxPixels)}
+	}
+	return nil
+}
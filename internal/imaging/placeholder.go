@@ -0,0 +1,72 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+const (
+	// lqipWidth is the width of the inline low-quality placeholder.
+	lqipWidth = 16
+	// blurHashWidth is the width images are reduced to before hashing.
+	blurHashWidth = 32
+)
+
+// Placeholders are small stand-ins a client can show while the real image
+// loads.
+type Placeholders struct {
+	BlurHash string
+	// LQIP is a tiny JPEG as a data: URI.
+	LQIP string
+}
+
+// MakePlaceholders computes a BlurHash and a low-quality image placeholder
+// for img.
+func MakePlaceholders(img image.Image) (Placeholders, error) {
+	bounds := img.Bounds()
+	xComponents, yComponents := 4, 3
+	if bounds.Dy() > bounds.Dx() {
+		xComponents, yComponents = 3, 4
+	}
+	hash := BlurHash(Resize(img, blurHashWidth), xComponents, yComponents)
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, Resize(img, lqipWidth), &jpeg.Options{Quality: 40})
+	if err != nil {
+		return Placeholders{}, err
+	}
+	return Placeholders{
+		BlurHash: hash,
+		LQIP:     "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// Resize scales img down to width, keeping its aspect ratio, by averaging
+// the source pixels under each destination pixel. Images already narrower
+// than width are copied at their own size.
+func Resize(img image.Image, width int) *image.RGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	width = min(width, srcW)
+	height := max(1, srcH*width/srcW)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcH/height, max((y+1)*srcH/height, y*srcH/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcW/width, max((x+1)*srcW/width, x*srcW/width+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,50 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+const (
+	AudioFormatM4A = "m4a"
+	AudioFormatMP3 = "mp3"
+)
+
+// AudioMediaType returns the MIME type of an audio rendition format.
+func AudioMediaType(format string) string {
+	switch format {
+	case AudioFormatM4A:
+		return "audio/mp4"
+	case AudioFormatMP3:
+		return "audio/mpeg"
+	}
+	return ""
+}
+
+// ExtractAudio writes the first audio stream of inputPath to outputPath as
+// an AAC .m4a or an MP3 file.
+func ExtractAudio(ctx context.Context, r Runner, inputPath, outputPath, format string) error {
+	args := []string{
+		"-y", "-hide_banner", "-nostats",
+		"-i", inputPath,
+		"-map", "0:a:0",
+		"-vn",
+	}
+	switch format {
+	case AudioFormatM4A:
+		args = append(args, "-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart", "-f", "ipod")
+	case AudioFormatMP3:
+		args = append(args, "-c:a", "libmp3lame", "-b:a", "128k", "-id3v2_version", "3", "-f", "mp3")
+	default:
+		return fmt.Errorf("unsupported audio format %q", format)
+	}
+	args = append(args, outputPath)
+
+	_, err := r.Run(ctx, Job{Program: ProgramFFmpeg, Args: args})
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// Chapter marks where a titled section of a video starts, in seconds.
+type Chapter struct {
+	Start float64
+	Title string
+}
+
+// EmbedChapters copies inputPath to outputPath with chapters written into
+// the container metadata. duration is used as the end of the last chapter.
+func EmbedChapters(ctx context.Context, r Runner, inputPath, outputPath string, chapters []Chapter, duration float64) error {
+	metadataPath := outputPath + ".ffmetadata"
+	err := os.WriteFile(metadataPath, []byte(ffmetadata(chapters, duration)), 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(metadataPath)
+
+	_, err = r.Run(ctx, Job{
+		Program: ProgramFFmpeg,
+		Args: []string{
+			"-y", "-hide_banner", "-nostats",
+			"-i", inputPath,
+			"-f", "ffmetadata", "-i", metadataPath,
+			"-map", "0:v",
+			"-map", "0:a?",
+			"-map_metadata", "0",
+			"-map_chapters", "1",
+			"-c", "copy",
+			"-f", "mp4",
+			outputPath,
+		},
+	})
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
+
+// ffmetadata renders chapters in ffmpeg's FFMETADATA1 format with
+// millisecond timestamps.
+func ffmetadata(chapters []Chapter, duration float64) string {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for i, ch := range chapters {
+		end := duration
+		if i+1 < len(chapters) {
+			end = chapters[i+1].Start
+		}
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(math.Round(ch.Start*1000)),
+			int64(math.Round(end*1000)),
+			escapeMetadata(ch.Title),
+		)
+	}
+	return b.String()
+}
+
+// escapeMetadata backslash-escapes the characters FFMETADATA treats
+// specially.
+func escapeMetadata(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		"=", `\=`,
+		";", `\;`,
+		"#", `\#`,
+		"\n", "\\\n",
+	)
+	return r.Replace(s)
+}
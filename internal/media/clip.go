@@ -0,0 +1,89 @@
+package media
+
+import (
+	"context"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// keyframeTolerance is how close, in seconds, a keyframe must be to the
+// requested start for a stream copy to count as accurate.
+const keyframeTolerance = 0.02
+
+// Cut writes the excerpt of inputPath between start and end seconds to
+// outputPath. When start falls on a keyframe the streams are copied;
+// otherwise the excerpt is re-encoded so it begins exactly at start. It
+// reports whether the streams were copied.
+func Cut(ctx context.Context, r Runner, inputPath, outputPath string, start, end float64) (bool, error) {
+	copyStreams, err := keyframeAt(ctx, r, inputPath, start)
+	if err != nil {
+		return false, err
+	}
+
+	args := []string{
+		"-y", "-hide_banner", "-nostats",
+		"-ss", formatSeconds(start),
+		"-i", inputPath,
+		"-t", formatSeconds(end - start),
+		"-map", "0:v:0",
+		"-map", "0:a?",
+	}
+	if copyStreams {
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+	} else {
+		args = append(args,
+			"-c:v", "libx264", "-preset", "medium", "-crf", "18", "-pix_fmt", "yuv420p",
+			"-c:a", "aac", "-b:a", "192k",
+		)
+	}
+	args = append(args, "-f", "mp4", outputPath)
+
+	_, err = r.Run(ctx, Job{Program: ProgramFFmpeg, Args: args})
+	if err != nil {
+		os.Remove(outputPath)
+		return false, err
+	}
+	return copyStreams, nil
+}
+
+// keyframeAt reports whether the first video stream has a keyframe within
+// keyframeTolerance of t.
+func keyframeAt(ctx context.Context, r Runner, path string, t float64) (bool, error) {
+	if t <= keyframeTolerance {
+		return true, nil
+	}
+	// read_intervals seeks to the keyframe at or before the interval start,
+	// so the listing always includes the candidate keyframe.
+	res, err := r.Run(ctx, Job{
+		Program: ProgramFFprobe,
+		Args: []string{
+			"-v", "error",
+			"-select_streams", "v:0",
+			"-skip_frame", "nokey",
+			"-show_entries", "frame=pts_time",
+			"-read_intervals", formatSeconds(t) + "%+1",
+			"-of", "csv=p=0",
+			path,
+		},
+		Timeout: probeTimeout,
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(res.Stdout), "\n") {
+		pts, err := strconv.ParseFloat(strings.Trim(strings.TrimSpace(line), ","), 64)
+		if err != nil {
+			continue
+		}
+		if math.Abs(pts-t) <= keyframeTolerance {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
@@ -0,0 +1,85 @@
+package media
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestCut(t *testing.T) {
+	tests := []struct {
+		name  string
+		start float64
+		// keyframes is ffprobe's listing of keyframes near start; nil
+		// expects no keyframe lookup.
+		keyframes []byte
+		wantCopy  bool
+	}{
+		{
+			name:     "from the start",
+			start:    0,
+			wantCopy: true,
+		},
+		{
+			name:      "on a keyframe",
+			start:     10,
+			keyframes: []byte("8.000000\n10.010000,\n12.000000\n"),
+			wantCopy:  true,
+		},
+		{
+			name:      "between keyframes",
+			start:     9,
+			keyframes: []byte("8.000000\n10.000000\n"),
+			wantCopy:  false,
+		},
+		{
+			name:      "no keyframes listed",
+			start:     9,
+			keyframes: []byte(""),
+			wantCopy:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var steps []FakeStep
+			if tt.keyframes != nil {
+				steps = append(steps, FakeStep{Program: ProgramFFprobe, Result: Result{Stdout: tt.keyframes}})
+			}
+			steps = append(steps, FakeStep{Program: ProgramFFmpeg})
+			runner := NewFakeRunner(steps...)
+
+			copied, err := Cut(context.Background(), runner, "in.mp4", "out.mp4", tt.start, tt.start+5)
+			if err != nil {
+				t.Fatalf("Cut() error = %v", err)
+			}
+			if copied != tt.wantCopy {
+				t.Errorf("Cut() copied = %t, want %t", copied, tt.wantCopy)
+			}
+
+			calls := runner.Calls()
+			args := calls[len(calls)-1].Args
+			if got := slices.Contains(args, "copy"); got != tt.wantCopy {
+				t.Errorf("ffmpeg args %v: stream copy = %t, want %t", args, got, tt.wantCopy)
+			}
+			if got := slices.Contains(args, "libx264"); got == tt.wantCopy {
+				t.Errorf("ffmpeg args %v: re-encode = %t, want %t", args, got, !tt.wantCopy)
+			}
+			if i := slices.Index(args, "-t"); i < 0 || args[i+1] != "5.000" {
+				t.Errorf("ffmpeg args %v: want a 5.000s duration", args)
+			}
+		})
+	}
+}
+
+func TestCutFailure(t *testing.T) {
+	runner := NewFakeRunner(
+		FakeStep{Program: ProgramFFprobe, Err: &Error{Program: ProgramFFprobe, ExitCode: 1}},
+	)
+	if _, err := Cut(context.Background(), runner, "in.mp4", "out.mp4", 3, 8); err == nil {
+		t.Errorf("Cut() succeeded although the keyframe lookup failed")
+	}
+	if n := len(runner.Calls()); n != 1 {
+		t.Errorf("Cut() ran %d jobs after the lookup failed, want 1", n)
+	}
+}
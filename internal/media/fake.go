@@ -0,0 +1,72 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakeStep is one scripted response of a FakeRunner.
+type FakeStep struct {
+	// Program, when set, must match the job's program.
+	Program string
+	Result  Result
+	Err     error
+	// Do, when set, is called instead of returning Result and Err. Use it
+	// to create the output files a real ffmpeg run would have written.
+	Do func(job Job) (Result, error)
+}
+
+// FakeRunner is a scripted Runner that lets handlers be exercised without
+// ffmpeg installed. Each call consumes the next step in order.
+type FakeRunner struct {
+	mu    sync.Mutex
+	steps []FakeStep
+	calls []Job
+}
+
+func NewFakeRunner(steps ...FakeStep) *FakeRunner {
+	return &FakeRunner{steps: steps}
+}
+
+func (f *FakeRunner) Run(ctx context.Context, job Job) (Result, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, job)
+	if len(f.steps) == 0 {
+		f.mu.Unlock()
+		return Result{}, fmt.Errorf("fake runner: unexpected %s call %v", job.Program, job.Args)
+	}
+	step := f.steps[0]
+	f.steps = f.steps[1:]
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return Result{}, &Error{Program: job.Program, Args: job.Args, Err: err}
+	}
+	if step.Program != "" && step.Program != job.Program {
+		return Result{}, fmt.Errorf("fake runner: expected %s call, got %s", step.Program, job.Program)
+	}
+	if step.Do != nil {
+		return step.Do(job)
+	}
+	if job.Stdout != nil && len(step.Result.Stdout) > 0 {
+		if _, err := job.Stdout.Write(step.Result.Stdout); err != nil {
+			return Result{}, err
+		}
+	}
+	return step.Result, step.Err
+}
+
+// Calls returns the jobs the runner has received so far.
+func (f *FakeRunner) Calls() []Job {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Job(nil), f.calls...)
+}
+
+// Remaining reports how many scripted steps have not been consumed.
+func (f *FakeRunner) Remaining() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.steps)
+}
@@ -0,0 +1,27 @@
+package media
+
+import (
+	"context"
+	"os"
+)
+
+// FastStart remuxes an MP4 so its moov atom precedes the media data,
+// letting players start before the whole file has downloaded.
+func FastStart(ctx context.Context, r Runner, inputPath, outputPath string) error {
+	_, err := r.Run(ctx, Job{
+		Program: ProgramFFmpeg,
+		Args: []string{
+			"-y",
+			"-i", inputPath,
+			"-c", "copy",
+			"-bsf:v", "h264_mp4toannexb",
+			"-f", "mp4", "-movflags", "faststart",
+			outputPath,
+		},
+	})
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	// fingerprintFrames is how many frames, spread evenly over the video,
+	// make up a fingerprint.
+	fingerprintFrames = 16
+	// dHash compares each pixel of a 9x8 grayscale thumbnail with its
+	// right-hand neighbour, giving 64 bits per frame.
+	dhashWidth  = 9
+	dhashHeight = 8
+)
+
+// Fingerprint is a perceptual fingerprint of a video: one difference hash
+// per sampled frame. Re-encoding, rescaling or recompressing a video
+// changes few bits, so similar videos have a small Distance.
+type Fingerprint []uint64
+
+// ComputeFingerprint samples frames evenly across the video at path and
+// hashes each of them.
+func ComputeFingerprint(ctx context.Context, r Runner, path string, duration float64) (Fingerprint, error) {
+	if duration <= 0 {
+		return nil, errors.New("duration must be positive")
+	}
+	var stdout bytes.Buffer
+	_, err := r.Run(ctx, Job{
+		Program: ProgramFFmpeg,
+		Args: []string{
+			"-hide_banner", "-nostats", "-nostdin",
+			"-i", path,
+			"-map", "0:v:0",
+			"-vf", fmt.Sprintf("fps=%.6f,scale=%d:%d:flags=area,format=gray", fingerprintFrames/duration, dhashWidth, dhashHeight),
+			"-frames:v", fmt.Sprint(fingerprintFrames),
+			"-f", "rawvideo", "-",
+		},
+		Stdout: &stdout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	const frameSize = dhashWidth * dhashHeight
+	raw := stdout.Bytes()
+	if len(raw) < frameSize {
+		return nil, errors.New("no frames decoded for fingerprint")
+	}
+	fp := Fingerprint{}
+	for len(raw) >= frameSize {
+		fp = append(fp, dhash(raw[:frameSize]))
+		raw = raw[frameSize:]
+	}
+	return fp, nil
+}
+
+func dhash(pixels []byte) uint64 {
+	var hash uint64
+	for y := 0; y < dhashHeight; y++ {
+		row := pixels[y*dhashWidth : (y+1)*dhashWidth]
+		for x := 0; x < dhashWidth-1; x++ {
+			hash <<= 1
+			if row[x] > row[x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Distance is the mean number of differing bits, from 0 to 64, between
+// the frame hashes of f and g, compared position by position.
+func (f Fingerprint) Distance(g Fingerprint) float64 {
+	n := min(len(f), len(g))
+	if n == 0 {
+		return 64
+	}
+	total := 0
+	for i := 0; i < n; i++ {
+		total += bits.OnesCount64(f[i] ^ g[i])
+	}
+	return float64(total) / float64(n)
+}
+
+// String encodes f as hex, 16 digits per frame.
+func (f Fingerprint) String() string {
+	b := make([]byte, 0, len(f)*8)
+	for _, h := range f {
+		b = append(b, byte(h>>56), byte(h>>48), byte(h>>40), byte(h>>32), byte(h>>24), byte(h>>16), byte(h>>8), byte(h))
+	}
+	return hex.EncodeToString(b)
+}
+
+// ParseFingerprint decodes the output of Fingerprint.String.
+func ParseFingerprint(s string) (Fingerprint, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b)%8 != 0 {
+		return nil, errors.New("invalid fingerprint")
+	}
+	fp := make(Fingerprint, len(b)/8)
+	for i := range fp {
+		for _, c := range b[i*8 : i*8+8] {
+			fp[i] = fp[i]<<8 | uint64(c)
+		}
+	}
+	return fp, nil
+}
@@ -0,0 +1,36 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// ExtractFrame writes the video frame shown at the given number of seconds
+// into the file at inputPath to outputPath as a full-resolution JPEG.
+func ExtractFrame(ctx context.Context, r Runner, inputPath, outputPath string, at float64) error {
+	_, err := r.Run(ctx, Job{
+		Program: ProgramFFmpeg,
+		Args: []string{
+			"-y", "-hide_banner", "-nostats", "-nostdin",
+			"-ss", formatSeconds(at),
+			"-i", inputPath,
+			"-map", "0:v:0",
+			"-frames:v", "1",
+			"-q:v", "2",
+			"-f", "image2",
+			outputPath,
+		},
+	})
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	// ffmpeg succeeds without writing anything when seeking past the
+	// last frame.
+	if info, err := os.Stat(outputPath); err != nil || info.Size() == 0 {
+		os.Remove(outputPath)
+		return errors.New("no frame at the requested time")
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+const (
+	// loudnessTruePeak and loudnessRange are the EBU R128 defaults used by
+	// ffmpeg's loudnorm filter.
+	loudnessTruePeak = -1.5
+	loudnessRange    = 11.0
+)
+
+var ErrSilentAudio = errors.New("audio is silent, loudness cannot be measured")
+
+// LoudnessStats are the values reported by the first loudnorm pass.
+type LoudnessStats struct {
+	IntegratedLUFS float64
+	TruePeak       float64
+	Range          float64
+	Threshold      float64
+	TargetOffset   float64
+}
+
+// MeasureLoudness runs the analysis pass of ffmpeg's two-pass EBU R128
+// loudnorm filter against the first audio stream of the input.
+func MeasureLoudness(ctx context.Context, r Runner, inputPath string, targetLUFS float64) (LoudnessStats, error) {
+	res, err := r.Run(ctx, Job{
+		Program: ProgramFFmpeg,
+		Args: []string{
+			"-hide_banner", "-nostats",
+			"-i", inputPath,
+			"-map", "0:a:0",
+			"-af", loudnormFilter(targetLUFS) + ":print_format=json",
+			"-f", "null", "-",
+		},
+	})
+	if err != nil {
+		return LoudnessStats{}, err
+	}
+
+	// loudnorm prints its JSON report as the last brace-delimited block on
+	// stderr, after ffmpeg's regular log output.
+	start := bytes.LastIndexByte(res.Stderr, '{')
+	end := bytes.LastIndexByte(res.Stderr, '}')
+	if start == -1 || end < start {
+		return LoudnessStats{}, fmt.Errorf("loudnorm report not found in ffmpeg output")
+	}
+	var report struct {
+		InputI       string `json:"input_i"`
+		InputTP      string `json:"input_tp"`
+		InputLRA     string `json:"input_lra"`
+		InputThresh  string `json:"input_thresh"`
+		TargetOffset string `json:"target_offset"`
+	}
+	if err := json.Unmarshal(res.Stderr[start:end+1], &report); err != nil {
+		return LoudnessStats{}, fmt.Errorf("couldn't parse loudnorm report: %w", err)
+	}
+
+	var stats LoudnessStats
+	fields := []struct {
+		raw string
+		dst *float64
+	}{
+		{report.InputI, &stats.IntegratedLUFS},
+		{report.InputTP, &stats.TruePeak},
+		{report.InputLRA, &stats.Range},
+		{report.InputThresh, &stats.Threshold},
+		{report.TargetOffset, &stats.TargetOffset},
+	}
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f.raw, 64)
+		if err != nil {
+			return LoudnessStats{}, fmt.Errorf("couldn't parse loudnorm value %q: %w", f.raw, err)
+		}
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return LoudnessStats{}, ErrSilentAudio
+		}
+		*f.dst = v
+	}
+	return stats, nil
+}
+
+// NormalizeLoudness runs the second loudnorm pass, using stats from
+// MeasureLoudness for linear normalization. Video streams are copied
+// untouched; the first audio stream is re-encoded to AAC and any others are
+// dropped.
+func NormalizeLoudness(ctx context.Context, r Runner, inputPath, outputPath string, targetLUFS float64, stats LoudnessStats) error {
+	filter := fmt.Sprintf(
+		"%s:measured_I=%.2f:measured_TP=%.2f:measured_LRA=%.2f:measured_thresh=%.2f:offset=%.2f:linear=true",
+		loudnormFilter(targetLUFS),
+		stats.IntegratedLUFS,
+		stats.TruePeak,
+		stats.Range,
+		stats.Threshold,
+		stats.TargetOffset,
+	)
+	_, err := r.Run(ctx, Job{
+		Program: ProgramFFmpeg,
+		Args: []string{
+			"-y", "-hide_banner", "-nostats",
+			"-i", inputPath,
+			"-map", "0:v",
+			"-map", "0:a:0",
+			"-c:v", "copy",
+			"-af", filter,
+			"-c:a", "aac", "-b:a", "192k", "-ar", "48000",
+			"-f", "mp4",
+			outputPath,
+		},
+	})
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
+
+func loudnormFilter(targetLUFS float64) string {
+	return fmt.Sprintf("loudnorm=I=%.1f:TP=%.1f:LRA=%.1f", targetLUFS, loudnessTruePeak, loudnessRange)
+}
@@ -0,0 +1,60 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// IsFastStart reports whether the MP4 at path already has its moov atom
+// ahead of its media data, in which case the fast-start remux is
+// unnecessary. Only the top-level box headers are read.
+func IsFastStart(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := info.Size()
+
+	var offset int64
+	header := make([]byte, 16)
+	for offset+8 <= size {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return false, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+
+		switch boxSize {
+		case 0:
+			// The box runs to the end of the file.
+			boxSize = size - offset
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return false, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize {
+			return false, errors.New("invalid MP4 box size")
+		}
+
+		switch boxType {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+		offset += boxSize
+	}
+	return false, io.ErrUnexpectedEOF
+}
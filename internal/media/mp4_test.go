@@ -0,0 +1,136 @@
+package media
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// box encodes an MP4 box with a payload of n zero bytes, using the 64-bit
+// size form when large is set.
+func box(boxType string, n int, large bool) []byte {
+	if large {
+		b := make([]byte, 16+n)
+		binary.BigEndian.PutUint32(b, 1)
+		copy(b[4:8], boxType)
+		binary.BigEndian.PutUint64(b[8:16], uint64(16+n))
+		return b
+	}
+	b := make([]byte, 8+n)
+	binary.BigEndian.PutUint32(b, uint32(8+n))
+	copy(b[4:8], boxType)
+	return b
+}
+
+// writeMP4 writes the boxes to a file in a temporary directory and returns
+// its path.
+func writeMP4(tb testing.TB, boxes ...[]byte) string {
+	tb.Helper()
+	var data []byte
+	for _, b := range boxes {
+		data = append(data, b...)
+	}
+	path := filepath.Join(tb.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+func TestIsFastStart(t *testing.T) {
+	tests := []struct {
+		name    string
+		boxes   [][]byte
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:  "moov first",
+			boxes: [][]byte{box("ftyp", 16, false), box("moov", 64, false), box("mdat", 1024, false)},
+			want:  true,
+		},
+		{
+			name:  "moov last",
+			boxes: [][]byte{box("ftyp", 16, false), box("mdat", 1024, false), box("moov", 64, false)},
+			want:  false,
+		},
+		{
+			name:  "large boxes",
+			boxes: [][]byte{box("ftyp", 16, false), box("free", 32, true), box("moov", 64, false), box("mdat", 1024, true)},
+			want:  true,
+		},
+		{
+			name:    "neither box",
+			boxes:   [][]byte{box("ftyp", 16, false), box("free", 32, false)},
+			wantErr: true,
+		},
+		{
+			name:    "box size smaller than its header",
+			boxes:   [][]byte{{0, 0, 0, 4, 'f', 't', 'y', 'p'}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := IsFastStart(writeMP4(t, tt.boxes...))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IsFastStart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsFastStart() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkIsFastStart measures the header check that lets an upload whose
+// moov atom already comes first skip the remux measured by
+// BenchmarkFastStart.
+func BenchmarkIsFastStart(b *testing.B) {
+	path := writeMP4(b, box("ftyp", 16, false), box("moov", 64<<10, false), box("mdat", 64<<20, false))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ok, err := IsFastStart(path); err != nil || !ok {
+			b.Fatalf("IsFastStart() = %t, %v", ok, err)
+		}
+	}
+}
+
+// BenchmarkFastStart measures the remux with ffmpeg on a ten second 720p
+// encode, so needs ffmpeg on the PATH.
+func BenchmarkFastStart(b *testing.B) {
+	if _, err := exec.LookPath(ProgramFFmpeg); err != nil {
+		b.Skip("ffmpeg not found")
+	}
+	ctx := context.Background()
+	runner := NewExecRunner(ExecConfig{})
+	dir := b.TempDir()
+	input := filepath.Join(dir, "input.mp4")
+	_, err := runner.Run(ctx, Job{
+		Program: ProgramFFmpeg,
+		Args: []string{
+			"-y",
+			"-f", "lavfi", "-i", "testsrc=duration=10:size=1280x720:rate=30",
+			"-c:v", "libx264", "-preset", "ultrafast",
+			input,
+		},
+	})
+	if err != nil {
+		b.Fatalf("couldn't encode input: %v", err)
+	}
+	if info, err := os.Stat(input); err == nil {
+		b.SetBytes(info.Size())
+	}
+
+	output := filepath.Join(dir, "output.mp4")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := FastStart(ctx, runner, input, output); err != nil {
+			b.Fatalf("FastStart() error = %v", err)
+		}
+	}
+}
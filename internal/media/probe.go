@@ -0,0 +1,120 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const probeTimeout = 30 * time.Second
+
+var ErrNoVideoStream = errors.New("no video streams found")
+
+type Stream struct {
+	Index        int    `json:"index"`
+	CodecType    string `json:"codec_type"`
+	CodecName    string `json:"codec_name"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	RFrameRate   string `json:"r_frame_rate"`
+	AvgFrameRate string `json:"avg_frame_rate"`
+}
+
+// FrameRate returns the stream's average frame rate, falling back to its
+// base rate, or 0 if ffprobe reported neither.
+func (s Stream) FrameRate() float64 {
+	if fps := parseRational(s.AvgFrameRate); fps > 0 {
+		return fps
+	}
+	return parseRational(s.RFrameRate)
+}
+
+// Format describes the container. ffprobe reports numbers as strings.
+type Format struct {
+	FormatName string `json:"format_name"`
+	// Duration is in seconds.
+	Duration string `json:"duration"`
+	// BitRate is the overall bit rate in bits per second.
+	BitRate string `json:"bit_rate"`
+}
+
+type ProbeResult struct {
+	Streams []Stream `json:"streams"`
+	Format  Format   `json:"format"`
+}
+
+// Duration returns the container duration in seconds, or 0 if unknown.
+func (p ProbeResult) Duration() float64 {
+	d, err := strconv.ParseFloat(p.Format.Duration, 64)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// VideoStream returns the first video stream in the file.
+func (p ProbeResult) VideoStream() (Stream, error) {
+	for _, s := range p.Streams {
+		if s.CodecType == "video" {
+			return s, nil
+		}
+	}
+	return Stream{}, ErrNoVideoStream
+}
+
+// BitRate returns the overall bit rate in bits per second, or 0 if unknown.
+func (p ProbeResult) BitRate() int64 {
+	b, err := strconv.ParseInt(p.Format.BitRate, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return b
+}
+
+// HasAudio reports whether the file has at least one audio stream.
+func (p ProbeResult) HasAudio() bool {
+	for _, s := range p.Streams {
+		if s.CodecType == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+func Probe(ctx context.Context, r Runner, path string) (ProbeResult, error) {
+	res, err := r.Run(ctx, Job{
+		Program: ProgramFFprobe,
+		Args:    []string{"-v", "error", "-print_format", "json", "-show_streams", "-show_format", path},
+		Timeout: probeTimeout,
+	})
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	var result ProbeResult
+	if err := json.Unmarshal(res.Stdout, &result); err != nil {
+		return ProbeResult{}, err
+	}
+	return result, nil
+}
+
+// parseRational parses ffprobe rates such as "30000/1001".
+func parseRational(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -0,0 +1,187 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Minimum lengths of the intervals the QC filters report, in seconds.
+const (
+	qcBlackMinSeconds   = 1
+	qcSilenceMinSeconds = 2
+	qcFreezeMinSeconds  = 2
+)
+
+// QC verdicts, from best to worst.
+const (
+	VerdictPass = "pass"
+	VerdictWarn = "warn"
+	VerdictFail = "fail"
+)
+
+// Interval is a span of a video in seconds.
+type Interval struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+func (i Interval) Length() float64 {
+	return i.End - i.Start
+}
+
+// QCIssue is a problem found by AnalyzeQuality. Severity is VerdictWarn or
+// VerdictFail.
+type QCIssue struct {
+	Code     string    `json:"code"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Interval *Interval `json:"interval,omitempty"`
+}
+
+// QCReport lists the black, silent and frozen stretches of a video and the
+// issues they add up to.
+type QCReport struct {
+	Verdict string     `json:"-"`
+	Black   []Interval `json:"black"`
+	Silence []Interval `json:"silence"`
+	Freeze  []Interval `json:"freeze"`
+	Issues  []QCIssue  `json:"issues"`
+}
+
+var (
+	blackPattern        = regexp.MustCompile(`black_start:\s*([\d.]+)\s+black_end:\s*([\d.]+)`)
+	silenceStartPattern = regexp.MustCompile(`silence_start:\s*(-?[\d.]+)`)
+	silenceEndPattern   = regexp.MustCompile(`silence_end:\s*([\d.]+)`)
+	freezeStartPattern  = regexp.MustCompile(`freeze_start:\s*([\d.]+)`)
+	freezeEndPattern    = regexp.MustCompile(`freeze_end:\s*([\d.]+)`)
+)
+
+// AnalyzeQuality decodes the video described by probe in one pass through
+// ffmpeg's blackdetect, freezedetect and silencedetect filters and assesses
+// what they find.
+func AnalyzeQuality(ctx context.Context, r Runner, path string, probe ProbeResult) (QCReport, error) {
+	args := []string{
+		"-hide_banner", "-nostats", "-nostdin",
+		"-i", path,
+		"-map", "0:v:0",
+		"-vf", fmt.Sprintf("blackdetect=d=%d:pix_th=0.10,freezedetect=n=-60dB:d=%d", qcBlackMinSeconds, qcFreezeMinSeconds),
+	}
+	if probe.HasAudio() {
+		args = append(args,
+			"-map", "0:a:0",
+			"-af", fmt.Sprintf("silencedetect=n=-50dB:d=%d", qcSilenceMinSeconds),
+		)
+	}
+	args = append(args, "-f", "null", "-")
+
+	res, err := r.Run(ctx, Job{Program: ProgramFFmpeg, Args: args})
+	if err != nil {
+		return QCReport{}, err
+	}
+
+	report := parseQCOutput(res.Stderr, probe.Duration())
+	report.assess(probe.Duration())
+	return report, nil
+}
+
+// parseQCOutput collects the intervals the detection filters log to
+// stderr. Silence and freezes still open at the end of the stream run to
+// duration.
+func parseQCOutput(stderr []byte, duration float64) QCReport {
+	report := QCReport{Black: []Interval{}, Silence: []Interval{}, Freeze: []Interval{}}
+	silenceStart, freezeStart := -1.0, -1.0
+
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := blackPattern.FindStringSubmatch(line); m != nil {
+			report.Black = append(report.Black, Interval{parseFloat(m[1]), parseFloat(m[2])})
+		}
+		if m := silenceStartPattern.FindStringSubmatch(line); m != nil {
+			silenceStart = max(0, parseFloat(m[1]))
+		}
+		if m := silenceEndPattern.FindStringSubmatch(line); m != nil && silenceStart >= 0 {
+			report.Silence = append(report.Silence, Interval{silenceStart, parseFloat(m[1])})
+			silenceStart = -1
+		}
+		if m := freezeStartPattern.FindStringSubmatch(line); m != nil {
+			freezeStart = parseFloat(m[1])
+		}
+		if m := freezeEndPattern.FindStringSubmatch(line); m != nil && freezeStart >= 0 {
+			report.Freeze = append(report.Freeze, Interval{freezeStart, parseFloat(m[1])})
+			freezeStart = -1
+		}
+	}
+	if silenceStart >= 0 && duration > silenceStart {
+		report.Silence = append(report.Silence, Interval{silenceStart, duration})
+	}
+	if freezeStart >= 0 && duration > freezeStart {
+		report.Freeze = append(report.Freeze, Interval{freezeStart, duration})
+	}
+	return report
+}
+
+// assess turns the detected intervals into issues and an overall verdict.
+// Long black intros and outros, long silences and long freezes are
+// warnings; a video that is mostly black or has silent audio fails.
+func (report *QCReport) assess(duration float64) {
+	report.Issues = []QCIssue{}
+	add := func(code, severity, message string, interval *Interval) {
+		report.Issues = append(report.Issues, QCIssue{code, severity, message, interval})
+	}
+
+	for _, black := range report.Black {
+		switch {
+		case black.Start <= 0.5 && black.Length() >= 3:
+			add("black_intro", VerdictWarn, fmt.Sprintf("Video opens with %.1fs of black", black.Length()), &black)
+		case black.End >= duration-0.5 && black.Length() >= 3:
+			add("black_outro", VerdictWarn, fmt.Sprintf("Video ends with %.1fs of black", black.Length()), &black)
+		}
+	}
+	if duration > 0 && total(report.Black) >= duration/2 {
+		add("mostly_black", VerdictFail, "More than half of the video is black", nil)
+	}
+
+	for _, silence := range report.Silence {
+		if silence.Length() >= 10 {
+			add("long_silence", VerdictWarn, fmt.Sprintf("Audio is silent for %.1fs", silence.Length()), &silence)
+		}
+	}
+	if duration > 0 && total(report.Silence) >= duration*0.9 {
+		add("silent_audio", VerdictFail, "Audio is silent for almost the whole video", nil)
+	}
+
+	// Frozen video is only ever a warning: slideshows and talks over a
+	// still image are legitimately static.
+	for _, freeze := range report.Freeze {
+		if freeze.Length() >= 10 {
+			add("long_freeze", VerdictWarn, fmt.Sprintf("Picture is frozen for %.1fs", freeze.Length()), &freeze)
+		}
+	}
+
+	report.Verdict = VerdictPass
+	for _, issue := range report.Issues {
+		if issue.Severity == VerdictFail {
+			report.Verdict = VerdictFail
+			break
+		}
+		report.Verdict = VerdictWarn
+	}
+}
+
+func total(intervals []Interval) float64 {
+	var sum float64
+	for _, i := range intervals {
+		sum += i.Length()
+	}
+	return sum
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
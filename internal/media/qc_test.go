@@ -0,0 +1,130 @@
+package media
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQCOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		duration float64
+		want     QCReport
+	}{
+		{
+			name:     "nothing detected",
+			stderr:   "frame=  300 fps=0.0 q=-0.0 Lsize=N/A time=00:00:10.00\n",
+			duration: 10,
+			want:     QCReport{Black: []Interval{}, Silence: []Interval{}, Freeze: []Interval{}},
+		},
+		{
+			name: "closed intervals",
+			stderr: `[blackdetect @ 0x1] black_start:0 black_end:3.5 black_duration:3.5
+[silencedetect @ 0x2] silence_start: 12.25
+[silencedetect @ 0x2] silence_end: 15.5 | silence_duration: 3.25
+[freezedetect @ 0x3] lavfi.freezedetect.freeze_start: 20
+[freezedetect @ 0x3] lavfi.freezedetect.freeze_duration: 4
+[freezedetect @ 0x3] lavfi.freezedetect.freeze_end: 24
+[blackdetect @ 0x1] black_start:58 black_end:60 black_duration:2
+`,
+			duration: 60,
+			want: QCReport{
+				Black:   []Interval{{0, 3.5}, {58, 60}},
+				Silence: []Interval{{12.25, 15.5}},
+				Freeze:  []Interval{{20, 24}},
+			},
+		},
+		{
+			name: "open intervals run to the end",
+			stderr: `[silencedetect @ 0x2] silence_start: 50
+[freezedetect @ 0x3] lavfi.freezedetect.freeze_start: 55.5
+`,
+			duration: 60,
+			want: QCReport{
+				Black:   []Interval{},
+				Silence: []Interval{{50, 60}},
+				Freeze:  []Interval{{55.5, 60}},
+			},
+		},
+		{
+			name:     "negative silence start is clamped",
+			stderr:   "[silencedetect @ 0x2] silence_start: -0.0213\n[silencedetect @ 0x2] silence_end: 4 | silence_duration: 4.02\n",
+			duration: 10,
+			want: QCReport{
+				Black:   []Interval{},
+				Silence: []Interval{{0, 4}},
+				Freeze:  []Interval{},
+			},
+		},
+		{
+			name:     "end without start is ignored",
+			stderr:   "[silencedetect @ 0x2] silence_end: 4 | silence_duration: 4\n",
+			duration: 10,
+			want:     QCReport{Black: []Interval{}, Silence: []Interval{}, Freeze: []Interval{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseQCOutput([]byte(tt.stderr), tt.duration)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseQCOutput() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQCReportAssess(t *testing.T) {
+	tests := []struct {
+		name     string
+		report   QCReport
+		duration float64
+		verdict  string
+		codes    []string
+	}{
+		{
+			name:     "clean",
+			duration: 60,
+			verdict:  VerdictPass,
+			codes:    []string{},
+		},
+		{
+			name:     "black intro and long freeze",
+			report:   QCReport{Black: []Interval{{0, 4}}, Freeze: []Interval{{20, 35}}},
+			duration: 60,
+			verdict:  VerdictWarn,
+			codes:    []string{"black_intro", "long_freeze"},
+		},
+		{
+			name:     "silent audio",
+			report:   QCReport{Silence: []Interval{{0, 58}}},
+			duration: 60,
+			verdict:  VerdictFail,
+			codes:    []string{"long_silence", "silent_audio"},
+		},
+		{
+			name:     "mostly black",
+			report:   QCReport{Black: []Interval{{10, 45}}},
+			duration: 60,
+			verdict:  VerdictFail,
+			codes:    []string{"mostly_black"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.report.assess(tt.duration)
+			if tt.report.Verdict != tt.verdict {
+				t.Errorf("Verdict = %q, want %q", tt.report.Verdict, tt.verdict)
+			}
+			codes := []string{}
+			for _, issue := range tt.report.Issues {
+				codes = append(codes, issue.Code)
+			}
+			if !reflect.DeepEqual(codes, tt.codes) {
+				t.Errorf("issue codes = %v, want %v", codes, tt.codes)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProgramFFmpeg  = "ffmpeg"
+	ProgramFFprobe = "ffprobe"
+
+	// stderrTailLines is how many trailing stderr lines an Error keeps.
+	stderrTailLines = 20
+)
+
+// Job describes a single ffmpeg or ffprobe invocation.
+type Job struct {
+	Program string
+	Args    []string
+	// Timeout overrides the runner's default timeout when non-zero.
+	Timeout time.Duration
+	// Stdout receives the process's standard output when set; otherwise it
+	// is buffered into Result.Stdout.
+	Stdout io.Writer
+}
+
+type Result struct {
+	Stdout []byte
+	Stderr []byte
+}
+
+// Runner executes media tool jobs. Implementations must honor ctx
+// cancellation.
+type Runner interface {
+	Run(ctx context.Context, job Job) (Result, error)
+}
+
+// Error is returned when a job fails. It keeps the tail of the process's
+// stderr so callers can log something more useful than "exit status 1".
+type Error struct {
+	Program  string
+	Args     []string
+	ExitCode int
+	TimedOut bool
+	Stderr   []string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s failed", e.Program)
+	if e.TimedOut {
+		msg = fmt.Sprintf("%s timed out", e.Program)
+	} else if e.ExitCode != 0 {
+		msg = fmt.Sprintf("%s exited with code %d", e.Program, e.ExitCode)
+	}
+	if len(e.Stderr) > 0 {
+		msg += ": " + e.Stderr[len(e.Stderr)-1]
+	} else if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ExecConfig controls how ExecRunner launches processes. Zero values disable
+// the corresponding limit.
+type ExecConfig struct {
+	FFmpegPath  string
+	FFprobePath string
+	// Timeout is the default per-job timeout.
+	Timeout time.Duration
+	// MaxConcurrent caps the number of jobs running at once across the
+	// whole process.
+	MaxConcurrent int
+	// Nice runs jobs through nice(1) with the given adjustment.
+	Nice int
+	// MaxMemoryBytes and MaxCPUSeconds run jobs through prlimit(1) to cap
+	// the address space and CPU time of each process.
+	MaxMemoryBytes int64
+	MaxCPUSeconds  int
+}
+
+// ExecRunner runs jobs as local processes.
+type ExecRunner struct {
+	cfg ExecConfig
+	sem chan struct{}
+}
+
+func NewExecRunner(cfg ExecConfig) *ExecRunner {
+	if cfg.FFmpegPath == "" {
+		cfg.FFmpegPath = ProgramFFmpeg
+	}
+	if cfg.FFprobePath == "" {
+		cfg.FFprobePath = ProgramFFprobe
+	}
+	r := &ExecRunner{cfg: cfg}
+	if cfg.MaxConcurrent > 0 {
+		r.sem = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return r
+}
+
+func (r *ExecRunner) Run(ctx context.Context, job Job) (Result, error) {
+	if r.sem != nil {
+		select {
+		case r.sem <- struct{}{}:
+			defer func() { <-r.sem }()
+		case <-ctx.Done():
+			return Result{}, &Error{Program: job.Program, Args: job.Args, Err: ctx.Err()}
+		}
+	}
+
+	timeout := job.Timeout
+	if timeout == 0 {
+		timeout = r.cfg.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	name, args, err := r.command(job)
+	if err != nil {
+		return Result{}, err
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = 5 * time.Second
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	if job.Stdout != nil {
+		cmd.Stdout = job.Stdout
+	}
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	result := Result{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	if err != nil {
+		jobErr := &Error{
+			Program:  job.Program,
+			Args:     job.Args,
+			ExitCode: -1,
+			Stderr:   tailLines(stderr.Bytes(), stderrTailLines),
+			Err:      err,
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			jobErr.ExitCode = exitErr.ExitCode()
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			jobErr.TimedOut = errors.Is(ctxErr, context.DeadlineExceeded)
+			jobErr.Err = ctxErr
+		}
+		return result, jobErr
+	}
+	return result, nil
+}
+
+// command builds the argv for a job, wrapping it in prlimit and nice when
+// resource limits are configured. Both tools exec the target in place, so
+// cancelling the context still kills the media process itself.
+func (r *ExecRunner) command(job Job) (string, []string, error) {
+	var name string
+	switch job.Program {
+	case ProgramFFmpeg:
+		name = r.cfg.FFmpegPath
+	case ProgramFFprobe:
+		name = r.cfg.FFprobePath
+	default:
+		return "", nil, fmt.Errorf("unsupported program %q", job.Program)
+	}
+	args := job.Args
+
+	if r.cfg.MaxMemoryBytes > 0 || r.cfg.MaxCPUSeconds > 0 {
+		limits := []string{}
+		if r.cfg.MaxMemoryBytes > 0 {
+			limits = append(limits, "--as="+strconv.FormatInt(r.cfg.MaxMemoryBytes, 10))
+		}
+		if r.cfg.MaxCPUSeconds > 0 {
+			limits = append(limits, "--cpu="+strconv.Itoa(r.cfg.MaxCPUSeconds))
+		}
+		args = append(append(limits, "--", name), args...)
+		name = "prlimit"
+	}
+	if r.cfg.Nice != 0 {
+		args = append([]string{"-n", strconv.Itoa(r.cfg.Nice), name}, args...)
+		name = "nice"
+	}
+	return name, args, nil
+}
+
+func tailLines(b []byte, n int) []string {
+	lines := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
@@ -0,0 +1,111 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Thumbnail image formats.
+const (
+	ImageFormatWebP = "webp"
+	ImageFormatJPEG = "jpg"
+)
+
+// ImageMediaType returns the MIME type of a thumbnail format.
+func ImageMediaType(format string) string {
+	switch format {
+	case ImageFormatWebP:
+		return "image/webp"
+	case ImageFormatJPEG:
+		return "image/jpeg"
+	}
+	return ""
+}
+
+// ThumbnailFile is one rendered size and format of a thumbnail.
+type ThumbnailFile struct {
+	Path   string
+	Format string
+	Width  int
+	Height int
+}
+
+// RenderThumbnails scales the image at inputPath, which is srcWidth by
+// srcHeight, to each of widths in both WebP and JPEG, writing the files to
+// outDir as "<width>.webp" and "<width>.jpg". Widths larger than the
+// source are skipped; if all of them are, the source width is used. All
+// files are written by a single ffmpeg run.
+func RenderThumbnails(ctx context.Context, r Runner, inputPath, outDir string, srcWidth, srcHeight int, widths []int) ([]ThumbnailFile, error) {
+	if srcWidth <= 0 || srcHeight <= 0 {
+		return nil, fmt.Errorf("invalid image size %dx%d", srcWidth, srcHeight)
+	}
+	targets := []int{}
+	for _, w := range widths {
+		if w <= srcWidth {
+			targets = append(targets, w)
+		}
+	}
+	if len(targets) == 0 {
+		targets = append(targets, max(srcWidth&^1, 2))
+	}
+
+	args := []string{"-y", "-hide_banner", "-nostats", "-nostdin", "-i", inputPath}
+	files := []ThumbnailFile{}
+	for _, w := range targets {
+		h := evenHeight(w, srcWidth, srcHeight)
+		scale := fmt.Sprintf("scale=%d:%d:flags=lanczos", w, h)
+		webp := ThumbnailFile{filepath.Join(outDir, fmt.Sprintf("%d.%s", w, ImageFormatWebP)), ImageFormatWebP, w, h}
+		jpeg := ThumbnailFile{filepath.Join(outDir, fmt.Sprintf("%d.%s", w, ImageFormatJPEG)), ImageFormatJPEG, w, h}
+		args = append(args,
+			"-map", "0:v:0", "-map_metadata", "-1", "-vf", scale, "-frames:v", "1",
+			"-c:v", "libwebp", "-quality", "80", "-f", "webp", webp.Path,
+			"-map", "0:v:0", "-map_metadata", "-1", "-vf", scale+",format=yuvj420p", "-frames:v", "1",
+			"-c:v", "mjpeg", "-q:v", "3", "-f", "image2", jpeg.Path,
+		)
+		files = append(files, webp, jpeg)
+	}
+
+	_, err := r.Run(ctx, Job{Program: ProgramFFmpeg, Args: args})
+	if err != nil {
+		for _, f := range files {
+			os.Remove(f.Path)
+		}
+		return nil, err
+	}
+	return files, nil
+}
+
+// evenHeight scales srcHeight to width, keeping the aspect ratio and
+// rounding to an even number as most encoders require.
+func evenHeight(width, srcWidth, srcHeight int) int {
+	h := int(math.Round(float64(width)*float64(srcHeight)/float64(srcWidth)/2)) * 2
+	return max(h, 2)
+}
+
+// ConvertImage decodes the first frame of an image ffmpeg understands but
+// Go doesn't, such as WebP, AVIF or HEIC, into a PNG at outputPath.
+func ConvertImage(ctx context.Context, r Runner, inputPath, outputPath string) error {
+	_, err := r.Run(ctx, Job{
+		Program: ProgramFFmpeg,
+		Args: []string{
+			"-y", "-hide_banner", "-nostats", "-nostdin",
+			"-i", inputPath,
+			"-map", "0:v:0",
+			"-map_metadata", "-1",
+			"-frames:v", "1",
+			"-c:v", "png",
+			"-f", "image2",
+			outputPath,
+		},
+		Timeout: time.Minute,
+	})
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Codes reported in ValidationError.Code.
+const (
+	CodeUnreadableMedia      = "unreadable_media"
+	CodeUnsupportedContainer = "unsupported_container"
+	CodeNoVideoStream        = "no_video_stream"
+	CodeDurationUnknown      = "duration_unknown"
+	CodeDurationTooLong      = "duration_too_long"
+	CodeResolutionTooHigh    = "resolution_too_high"
+	CodeBitrateTooHigh       = "bitrate_too_high"
+	CodeFrameRateTooHigh     = "frame_rate_too_high"
+	CodeDecodeFailed         = "decode_failed"
+	CodeTruncated            = "truncated_file"
+)
+
+// decodeTestSeconds is how much video is decoded at each end of the file
+// by the decode test.
+const decodeTestSeconds = 2
+
+// Limits bound what Validate accepts. Zero values disable a check. Width
+// and height are given for landscape video; portrait video is compared with
+// the limits swapped.
+type Limits struct {
+	MaxDuration  time.Duration
+	MaxWidth     int
+	MaxHeight    int
+	MaxBitRate   int64
+	MaxFrameRate float64
+}
+
+// ValidationError explains which rule an upload broke. Code is stable and
+// meant for clients; Message is for people.
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Validate probes the file at path and checks it against limits, then
+// decodes a few seconds from its start and end to catch corrupt or
+// truncated files. It returns the probe result so callers don't need to
+// probe again.
+func Validate(ctx context.Context, r Runner, path string, limits Limits) (ProbeResult, error) {
+	probe, err := Probe(ctx, r, path)
+	if err != nil {
+		if isJobFailure(err) {
+			return ProbeResult{}, &ValidationError{CodeUnreadableMedia, "File is not a readable media file"}
+		}
+		return ProbeResult{}, err
+	}
+
+	if !strings.Contains(probe.Format.FormatName, "mp4") {
+		return probe, &ValidationError{CodeUnsupportedContainer, "File is not an MP4 video"}
+	}
+
+	stream, err := probe.VideoStream()
+	if err != nil || stream.CodecName == "" || stream.Width <= 0 || stream.Height <= 0 {
+		return probe, &ValidationError{CodeNoVideoStream, "File has no decodable video stream"}
+	}
+
+	duration := probe.Duration()
+	if duration <= 0 {
+		return probe, &ValidationError{CodeDurationUnknown, "Couldn't determine the video's duration"}
+	}
+	if limits.MaxDuration > 0 && duration > limits.MaxDuration.Seconds() {
+		return probe, &ValidationError{
+			CodeDurationTooLong,
+			fmt.Sprintf("Video is %s long; the maximum is %s", formatDuration(duration), limits.MaxDuration),
+		}
+	}
+
+	if limits.MaxWidth > 0 && limits.MaxHeight > 0 {
+		long, short := max(stream.Width, stream.Height), min(stream.Width, stream.Height)
+		maxLong, maxShort := max(limits.MaxWidth, limits.MaxHeight), min(limits.MaxWidth, limits.MaxHeight)
+		if long > maxLong || short > maxShort {
+			return probe, &ValidationError{
+				CodeResolutionTooHigh,
+				fmt.Sprintf("Video is %dx%d; the maximum is %dx%d", stream.Width, stream.Height, limits.MaxWidth, limits.MaxHeight),
+			}
+		}
+	}
+
+	if bitRate := probe.BitRate(); limits.MaxBitRate > 0 && bitRate > limits.MaxBitRate {
+		return probe, &ValidationError{
+			CodeBitrateTooHigh,
+			fmt.Sprintf("Video bit rate is %d kb/s; the maximum is %d kb/s", bitRate/1000, limits.MaxBitRate/1000),
+		}
+	}
+
+	if fps := stream.FrameRate(); limits.MaxFrameRate > 0 && fps > limits.MaxFrameRate+0.01 {
+		return probe, &ValidationError{
+			CodeFrameRateTooHigh,
+			fmt.Sprintf("Video is %.2f fps; the maximum is %.2f fps", fps, limits.MaxFrameRate),
+		}
+	}
+
+	if err := decodeTest(ctx, r, path, false); err != nil {
+		if isJobFailure(err) {
+			return probe, &ValidationError{CodeDecodeFailed, "Video couldn't be decoded"}
+		}
+		return probe, err
+	}
+	if duration > decodeTestSeconds {
+		if err := decodeTest(ctx, r, path, true); err != nil {
+			if isJobFailure(err) {
+				return probe, &ValidationError{CodeTruncated, "Video appears to be truncated or corrupt near the end"}
+			}
+			return probe, err
+		}
+	}
+	return probe, nil
+}
+
+// decodeTest decodes the first video stream for a couple of seconds from
+// the start, or from the end when fromEnd is set, failing on the first
+// decoding error.
+func decodeTest(ctx context.Context, r Runner, path string, fromEnd bool) error {
+	args := []string{"-v", "error", "-xerror", "-nostdin"}
+	if fromEnd {
+		args = append(args, "-sseof", fmt.Sprintf("-%d", decodeTestSeconds))
+	}
+	args = append(args,
+		"-i", path,
+		"-t", fmt.Sprintf("%d", decodeTestSeconds),
+		"-map", "0:v:0",
+		"-f", "null", "-",
+	)
+	_, err := r.Run(ctx, Job{Program: ProgramFFmpeg, Args: args, Timeout: time.Minute})
+	return err
+}
+
+// isJobFailure reports whether err is the tool rejecting its input, as
+// opposed to a timeout or cancellation.
+func isJobFailure(err error) bool {
+	var jobErr *Error
+	return errors.As(err, &jobErr) && !jobErr.TimedOut && jobErr.ExitCode > 0
+}
+
+func formatDuration(seconds float64) string {
+	return time.Duration(math.Round(seconds) * float64(time.Second)).String()
+}
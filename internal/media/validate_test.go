@@ -0,0 +1,178 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// probeOutput is ffprobe's JSON for a file with the given container, video
+// stream and duration. A zero width leaves out the video stream.
+func probeOutput(t *testing.T, formatName string, width, height int, fps, duration, bitRate string) []byte {
+	t.Helper()
+	probe := ProbeResult{
+		Streams: []Stream{{Index: 1, CodecType: "audio", CodecName: "aac"}},
+		Format:  Format{FormatName: formatName, Duration: duration, BitRate: bitRate},
+	}
+	if width > 0 {
+		probe.Streams = append(probe.Streams, Stream{
+			CodecType:    "video",
+			CodecName:    "h264",
+			Width:        width,
+			Height:       height,
+			RFrameRate:   fps,
+			AvgFrameRate: fps,
+		})
+	}
+	out, err := json.Marshal(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestValidate(t *testing.T) {
+	const mp4 = "mov,mp4,m4a,3gp,3g2,mj2"
+	limits := Limits{
+		MaxDuration:  time.Hour,
+		MaxWidth:     1920,
+		MaxHeight:    1080,
+		MaxBitRate:   10_000_000,
+		MaxFrameRate: 30,
+	}
+	rejected := &Error{Program: ProgramFFmpeg, ExitCode: 1}
+	timedOut := &Error{Program: ProgramFFmpeg, ExitCode: -1, TimedOut: true, Err: context.DeadlineExceeded}
+
+	tests := []struct {
+		name  string
+		steps []FakeStep
+		// code is the expected ValidationError code; "" expects success,
+		// "error" any other error.
+		code string
+	}{
+		{
+			name: "valid",
+			steps: []FakeStep{
+				{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 1920, 1080, "30/1", "60.0", "5000000")}},
+				{Program: ProgramFFmpeg},
+				{Program: ProgramFFmpeg},
+			},
+		},
+		{
+			name: "portrait within swapped limits",
+			steps: []FakeStep{
+				{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 1080, 1920, "30000/1001", "60.0", "5000000")}},
+				{Program: ProgramFFmpeg},
+				{Program: ProgramFFmpeg},
+			},
+		},
+		{
+			name: "short video skips the end decode test",
+			steps: []FakeStep{
+				{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 640, 360, "30/1", "1.5", "")}},
+				{Program: ProgramFFmpeg},
+			},
+		},
+		{
+			name:  "unreadable",
+			steps: []FakeStep{{Program: ProgramFFprobe, Err: &Error{Program: ProgramFFprobe, ExitCode: 1}}},
+			code:  CodeUnreadableMedia,
+		},
+		{
+			name:  "probe timeout is not the upload's fault",
+			steps: []FakeStep{{Program: ProgramFFprobe, Err: &Error{Program: ProgramFFprobe, ExitCode: -1, TimedOut: true}}},
+			code:  "error",
+		},
+		{
+			name:  "not mp4",
+			steps: []FakeStep{{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, "matroska,webm", 1920, 1080, "30/1", "60.0", "")}}},
+			code:  CodeUnsupportedContainer,
+		},
+		{
+			name:  "audio only",
+			steps: []FakeStep{{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 0, 0, "", "60.0", "")}}},
+			code:  CodeNoVideoStream,
+		},
+		{
+			name:  "unknown duration",
+			steps: []FakeStep{{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 1920, 1080, "30/1", "N/A", "")}}},
+			code:  CodeDurationUnknown,
+		},
+		{
+			name:  "too long",
+			steps: []FakeStep{{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 1920, 1080, "30/1", "3601", "")}}},
+			code:  CodeDurationTooLong,
+		},
+		{
+			name:  "resolution too high",
+			steps: []FakeStep{{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 3840, 2160, "30/1", "60.0", "")}}},
+			code:  CodeResolutionTooHigh,
+		},
+		{
+			name:  "bit rate too high",
+			steps: []FakeStep{{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 1920, 1080, "30/1", "60.0", "20000000")}}},
+			code:  CodeBitrateTooHigh,
+		},
+		{
+			name:  "frame rate too high",
+			steps: []FakeStep{{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 1920, 1080, "60/1", "60.0", "")}}},
+			code:  CodeFrameRateTooHigh,
+		},
+		{
+			name: "corrupt start",
+			steps: []FakeStep{
+				{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 1920, 1080, "30/1", "60.0", "")}},
+				{Program: ProgramFFmpeg, Err: rejected},
+			},
+			code: CodeDecodeFailed,
+		},
+		{
+			name: "truncated end",
+			steps: []FakeStep{
+				{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 1920, 1080, "30/1", "60.0", "")}},
+				{Program: ProgramFFmpeg},
+				{Program: ProgramFFmpeg, Err: rejected},
+			},
+			code: CodeTruncated,
+		},
+		{
+			name: "decode timeout is not the upload's fault",
+			steps: []FakeStep{
+				{Program: ProgramFFprobe, Result: Result{Stdout: probeOutput(t, mp4, 1920, 1080, "30/1", "60.0", "")}},
+				{Program: ProgramFFmpeg, Err: timedOut},
+			},
+			code: "error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := NewFakeRunner(tt.steps...)
+			_, err := Validate(context.Background(), runner, "upload.mp4", limits)
+
+			var validationErr *ValidationError
+			switch {
+			case tt.code == "":
+				if err != nil {
+					t.Fatalf("Validate() error = %v, want none", err)
+				}
+			case tt.code == "error":
+				if err == nil || errors.As(err, &validationErr) {
+					t.Fatalf("Validate() error = %v, want a non-validation error", err)
+				}
+			default:
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("Validate() error = %v, want code %s", err, tt.code)
+				}
+				if validationErr.Code != tt.code {
+					t.Errorf("Validate() code = %s, want %s", validationErr.Code, tt.code)
+				}
+			}
+			if n := runner.Remaining(); n != 0 {
+				t.Errorf("%d scripted steps weren't run", n)
+			}
+		})
+	}
+}
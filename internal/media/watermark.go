@@ -0,0 +1,86 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+const (
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+	PositionCenter      = "center"
+)
+
+// Overlay describes where and how a watermark image is burned into a video.
+type Overlay struct {
+	ImagePath string
+	Position  string
+	// Margin is the distance in pixels from the nearest edges. It is
+	// ignored for PositionCenter.
+	Margin int
+	// Width is the rendered watermark width in pixels; the height follows
+	// the image's aspect ratio.
+	Width   int
+	Opacity float64
+}
+
+// ValidPosition reports whether p is a supported overlay position.
+func ValidPosition(p string) bool {
+	_, err := overlayCoordinates(p, 0)
+	return err == nil
+}
+
+// ApplyWatermark re-encodes the video of inputPath with the overlay burned
+// in. Audio streams are copied untouched.
+func ApplyWatermark(ctx context.Context, r Runner, inputPath, outputPath string, overlay Overlay) error {
+	coords, err := overlayCoordinates(overlay.Position, overlay.Margin)
+	if err != nil {
+		return err
+	}
+	filter := fmt.Sprintf(
+		"[1:v]scale=%d:-1,format=rgba,colorchannelmixer=aa=%.3f[wm];[0:v][wm]overlay=%s:format=auto[v]",
+		overlay.Width,
+		overlay.Opacity,
+		coords,
+	)
+
+	_, err = r.Run(ctx, Job{
+		Program: ProgramFFmpeg,
+		Args: []string{
+			"-y", "-hide_banner", "-nostats",
+			"-i", inputPath,
+			"-i", overlay.ImagePath,
+			"-filter_complex", filter,
+			"-map", "[v]",
+			"-map", "0:a?",
+			"-c:v", "libx264", "-preset", "medium", "-crf", "20", "-pix_fmt", "yuv420p",
+			"-c:a", "copy",
+			"-f", "mp4",
+			outputPath,
+		},
+	})
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
+
+func overlayCoordinates(position string, margin int) (string, error) {
+	switch position {
+	case PositionTopLeft:
+		return fmt.Sprintf("%d:%d", margin, margin), nil
+	case PositionTopRight:
+		return fmt.Sprintf("W-w-%d:%d", margin, margin), nil
+	case PositionBottomLeft:
+		return fmt.Sprintf("%d:H-h-%d", margin, margin), nil
+	case PositionBottomRight:
+		return fmt.Sprintf("W-w-%d:H-h-%d", margin, margin), nil
+	case PositionCenter:
+		return "(W-w)/2:(H-h)/2", nil
+	}
+	return "", fmt.Errorf("unsupported watermark position %q", position)
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 serves one bucket's objects for HeadObject and ranged GetObject
+// requests, recording the Range of each GET.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	etags   map[string]string
+	ranges  []string
+}
+
+func (f *fakeS3) put(key string, data []byte, etag string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = data
+	f.etags[key] = etag
+}
+
+func (f *fakeS3) gets() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.ranges...)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	data, ok := f.objects[key]
+	if !ok {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+		return
+	}
+	w.Header().Set("ETag", f.etags[key])
+	w.Header().Set("Last-Modified", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+	w.Header().Set("Content-Type", "video/mp4")
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	case http.MethodGet:
+		f.ranges = append(f.ranges, r.Header.Get("Range"))
+		if match := r.Header.Get("If-Match"); match != "" && match != f.etags[key] {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, `<Error><Code>PreconditionFailed</Code><Message>changed</Message></Error>`)
+			return
+		}
+		var start int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err != nil || start >= len(data) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)-start))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start:])
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestS3(t *testing.T) (*S3, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{objects: map[string][]byte{}, etags: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("id", "secret", ""),
+	})
+	return NewS3(client, "bucket"), fake
+}
+
+func TestS3ObjectRead(t *testing.T) {
+	store, fake := newTestS3(t)
+	data := []byte("0123456789abcdefghij")
+	fake.put("videos/a.mp4", data, `"v1"`)
+
+	object, err := store.Open(context.Background(), "videos/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+
+	info := object.Info()
+	if info.Size != int64(len(data)) || info.ETag != `"v1"` || info.ContentType != "video/mp4" || !info.ModTime.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Info() = %+v", info)
+	}
+	if len(fake.gets()) != 0 {
+		t.Errorf("Open made GETs %q, want none", fake.gets())
+	}
+
+	got, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("ReadAll() = %q, want %q", got, data)
+	}
+
+	// Seeking to the current offset keeps the open body; any other
+	// offset starts a new GET from there.
+	steps := []struct {
+		offset int64
+		whence int
+		want   string
+	}{
+		{10, io.SeekStart, "abcde"},
+		{0, io.SeekCurrent, "fghij"},
+		{-5, io.SeekEnd, "fghij"},
+		{-18, io.SeekCurrent, "23456"},
+	}
+	for _, step := range steps {
+		if _, err := object.Seek(step.offset, step.whence); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(object, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != step.want {
+			t.Errorf("read after Seek(%d, %d) = %q, want %q", step.offset, step.whence, buf, step.want)
+		}
+	}
+	wantRanges := []string{"bytes=0-", "bytes=10-", "bytes=15-", "bytes=2-"}
+	if got := fake.gets(); strings.Join(got, " ") != strings.Join(wantRanges, " ") {
+		t.Errorf("GETs = %q, want %q", got, wantRanges)
+	}
+
+	// Reading at the end needs no request.
+	if _, err := object.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := object.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read at end = %d, %v, want 0, EOF", n, err)
+	}
+	if len(fake.gets()) != len(wantRanges) {
+		t.Errorf("Read at end made a GET")
+	}
+
+	if _, err := object.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek before the start succeeded")
+	}
+}
+
+func TestS3ObjectReplaced(t *testing.T) {
+	store, fake := newTestS3(t)
+	fake.put("videos/a.mp4", []byte("first version"), `"v1"`)
+
+	object, err := store.Open(context.Background(), "videos/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	fake.put("videos/a.mp4", []byte("second version"), `"v2"`)
+
+	if _, err := io.ReadAll(object); err == nil {
+		t.Error("read of a replaced object succeeded")
+	}
+}
+
+func TestS3OpenMissing(t *testing.T) {
+	store, _ := newTestS3(t)
+	if _, err := store.Open(context.Background(), "videos/missing.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open() error = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Get(context.Background(), "videos/missing.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v, want ErrNotFound", err)
+	}
+}
+
+// TestS3ObjectServeContent serves an object the way handlerVideoStream
+// does, answering a Range request with a single ranged GET.
+func TestS3ObjectServeContent(t *testing.T) {
+	store, fake := newTestS3(t)
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	fake.put("videos/a.mp4", data, `"v1"`)
+
+	object, err := store.Open(context.Background(), "videos/a.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	req.Header.Set("Range", "bytes=500-509")
+	rec := httptest.NewRecorder()
+	rec.Header().Set("ETag", object.Info().ETag)
+	http.ServeContent(rec, req, "a.mp4", object.Info().ModTime, object)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	if got := rec.Body.String(); got != "0123456789" {
+		t.Errorf("body = %q, want %q", got, "0123456789")
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 500-509/1000" {
+		t.Errorf("Content-Range = %q", got)
+	}
+	if got := fake.gets(); len(got) != 1 || got[0] != "bytes=500-" {
+		t.Errorf("GETs = %q, want one from byte 500", got)
+	}
+}
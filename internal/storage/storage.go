@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Store is a blob store addressed by slash-separated keys.
+type Store interface {
+	// Put writes body under key, replacing any existing object. Bodies
+	// should be seekable (an *os.File or *bytes.Reader) so backends can
+	// compute checksums and retry.
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	// Get opens the object stored under key. It returns ErrNotFound if
+	// there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// Open opens the object stored under key for reading from any offset,
+	// as serving Range requests needs. It returns ErrNotFound if there is
+	// none.
+	Open(ctx context.Context, key string) (Object, error)
+}
+
+// Object is an open stored object.
+type Object interface {
+	io.ReadSeekCloser
+	Info() ObjectInfo
+}
+
+// ObjectInfo describes a stored object. ETag is quoted, ready for an HTTP
+// header; ContentType may be empty if the backend doesn't record it.
+type ObjectInfo struct {
+	Size        int64
+	ModTime     time.Time
+	ETag        string
+	ContentType string
+}
+
+// Presigner is implemented by stores that can hand out temporary URLs for
+// downloading an object directly.
+type Presigner interface {
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
@@ -21,6 +21,22 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	})
 }
 
+// respondWithErrorCode is respondWithError with a machine-readable code
+// alongside the message, for errors clients are expected to act on.
+func respondWithErrorCode(w http.ResponseWriter, code int, errCode, msg string, err error) {
+	if err != nil {
+		log.Println(err)
+	}
+	type errorResponse struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	respondWithJSON(w, code, errorResponse{
+		Error: msg,
+		Code:  errCode,
+	})
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultVideoKeyTemplate = "{orientation}/{random}.{ext}"
+
+	renditionVideo    = "video"
+	renditionOriginal = "original"
+	renditionAudio    = "audio"
+
+	// Files stored alongside the media use their kind in place of an
+	// orientation; see renderSidecar.
+	kindCaptions  = "captions"
+	kindChapters  = "chapters"
+	kindThumbnail = "thumbnails"
+	kindWatermark = "watermarks"
+)
+
+// keyFields lists the placeholders a key template may reference.
+var keyFields = map[string]bool{
+	"user_id":     true,
+	"video_id":    true,
+	"orientation": true,
+	"rendition":   true,
+	"ext":         true,
+	"random":      true,
+	"version":     true,
+	"year":        true,
+	"month":       true,
+	"day":         true,
+}
+
+// keyTemplate renders object storage keys from a pattern such as
+// "{user_id}/{video_id}/{version}/{rendition}.{ext}".
+type keyTemplate struct {
+	raw   string
+	parts []keyPart
+}
+
+// keyPart is either a literal run of text or a placeholder name.
+type keyPart struct {
+	literal string
+	field   string
+}
+
+// keyValues fill a keyTemplate. Audio-only renditions have no picture, so
+// they use renditionAudio as their orientation. Version is the number of
+// the video version the file belongs to; files published before versions
+// were recorded, and those that belong to no version, use 0.
+type keyValues struct {
+	UserID      uuid.UUID
+	VideoID     uuid.UUID
+	Version     int
+	Orientation string
+	Rendition   string
+	Ext         string
+}
+
+func parseKeyTemplate(s string) (keyTemplate, error) {
+	if strings.TrimSpace(s) == "" {
+		return keyTemplate{}, fmt.Errorf("key template is empty")
+	}
+	if strings.HasPrefix(s, "/") {
+		return keyTemplate{}, fmt.Errorf("key template %q must not start with /", s)
+	}
+	for _, segment := range strings.Split(s, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return keyTemplate{}, fmt.Errorf("key template %q contains an empty or relative path segment", s)
+		}
+	}
+
+	t := keyTemplate{raw: s}
+	rest := s
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		if open == -1 {
+			t.parts = append(t.parts, keyPart{literal: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return keyTemplate{}, fmt.Errorf("key template %q has an unmatched }", s)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, keyPart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end == -1 {
+			return keyTemplate{}, fmt.Errorf("key template %q has an unmatched {", s)
+		}
+		field := rest[open+1 : open+end]
+		if !keyFields[field] {
+			return keyTemplate{}, fmt.Errorf("key template %q has unknown placeholder {%s}", s, field)
+		}
+		t.parts = append(t.parts, keyPart{field: field})
+		rest = rest[open+end+1:]
+	}
+	// Without {random}, keys are only unique if the template names the
+	// video, the version and the file: otherwise uploads overwrite each
+	// other's files, including those kept for rollback.
+	deterministic := t.uses("video_id") && t.uses("version") && t.uses("rendition") && t.uses("ext")
+	if !t.uses("random") && !deterministic {
+		return keyTemplate{}, fmt.Errorf("key template %q must use {random}, or all of {video_id}, {version}, {rendition} and {ext}, so every file gets a distinct key", s)
+	}
+	return t, nil
+}
+
+func (t keyTemplate) uses(field string) bool {
+	for _, part := range t.parts {
+		if part.field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// videoPrefixDepth returns how many leading segments of a rendered key
+// name a directory holding only one video's files: those up to the first
+// directory segment using {video_id}. It returns 0 if no directory segment
+// uses it.
+func (t keyTemplate) videoPrefixDepth() int {
+	segments := strings.Split(t.raw, "/")
+	for i, segment := range segments[:len(segments)-1] {
+		if strings.Contains(segment, "{video_id}") {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// renderSidecar renders the key of a file stored alongside a video's media,
+// such as a caption track, which doesn't belong to any one version. kind
+// stands in for the orientation; the rendition is kind, then name if not
+// empty, then random hex, so every upload of the file gets a fresh key even
+// under templates without {random} and caches never serve an old copy.
+// Files that belong to a user rather than a video, such as watermarks,
+// pass uuid.Nil as videoID.
+func (t keyTemplate) renderSidecar(userID, videoID uuid.UUID, kind, name, ext string) (string, error) {
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	rendition := kind
+	if name != "" {
+		rendition += "-" + name
+	}
+	rendition += "-" + hex.EncodeToString(randomBytes)
+	return t.render(keyValues{
+		UserID:      userID,
+		VideoID:     videoID,
+		Orientation: kind,
+		Rendition:   rendition,
+		Ext:         ext,
+	})
+}
+
+func (t keyTemplate) String() string {
+	return t.raw
+}
+
+// render expands the template. {random} is filled with fresh random hex on
+// every call, so templates without it produce the same key each time a
+// version's rendition is published, e.g. when it is reprocessed.
+func (t keyTemplate) render(v keyValues) (string, error) {
+	now := time.Now().UTC()
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		var value string
+		switch part.field {
+		case "user_id":
+			value = v.UserID.String()
+		case "video_id":
+			value = v.VideoID.String()
+		case "version":
+			value = strconv.Itoa(v.Version)
+		case "orientation":
+			value = v.Orientation
+		case "rendition":
+			value = v.Rendition
+		case "ext":
+			value = strings.TrimPrefix(v.Ext, ".")
+		case "random":
+			randomBytes := make([]byte, 16)
+			if _, err := rand.Read(randomBytes); err != nil {
+				return "", err
+			}
+			value = hex.EncodeToString(randomBytes)
+		case "year":
+			value = now.Format("2006")
+		case "month":
+			value = now.Format("01")
+		case "day":
+			value = now.Format("02")
+		}
+		if value == "" || strings.Contains(value, "/") {
+			return "", fmt.Errorf("invalid value %q for key placeholder {%s}", value, part.field)
+		}
+		b.WriteString(value)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseKeyTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  bool
+	}{
+		{template: defaultVideoKeyTemplate},
+		{template: "{user_id}/{video_id}/{version}/{rendition}.{ext}"},
+		{template: "{year}/{month}/{day}/{random}"},
+		{template: "videos/{video_id}-{version}-{rendition}.{ext}"},
+		{template: "", wantErr: true},
+		{template: "   ", wantErr: true},
+		{template: "/{random}.{ext}", wantErr: true},
+		{template: "a//{random}", wantErr: true},
+		{template: "a/../{random}", wantErr: true},
+		{template: "./{random}", wantErr: true},
+		{template: "{random", wantErr: true},
+		{template: "random}/{ext}", wantErr: true},
+		{template: "{uuid}/{random}", wantErr: true},
+		{template: "{Random}", wantErr: true},
+		// Without {random}, the video, version and file must all be named.
+		{template: "{user_id}/{rendition}.{ext}", wantErr: true},
+		{template: "{video_id}/{rendition}.{ext}", wantErr: true},
+		{template: "{video_id}/{version}.{ext}", wantErr: true},
+		{template: "{video_id}/{version}/{rendition}", wantErr: true},
+		{template: "{orientation}/{video_id}.{ext}", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := parseKeyTemplate(tt.template)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseKeyTemplate(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+		}
+	}
+}
+
+func TestKeyTemplateRender(t *testing.T) {
+	userID := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	videoID := uuid.MustParse("22222222-2222-2222-2222-222222222222")
+	values := keyValues{
+		UserID:      userID,
+		VideoID:     videoID,
+		Version:     3,
+		Orientation: "landscape",
+		Rendition:   renditionVideo,
+		Ext:         ".mp4",
+	}
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name     string
+		template string
+		values   keyValues
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "every field",
+			template: "{user_id}/{video_id}/{version}/{orientation}/{rendition}.{ext}",
+			values:   values,
+			want:     userID.String() + "/" + videoID.String() + "/3/landscape/video.mp4",
+		},
+		{
+			name:     "extension without a dot",
+			template: "{video_id}/{version}/{rendition}.{ext}",
+			values:   keyValues{VideoID: videoID, Version: 1, Rendition: renditionAudio, Ext: "m4a"},
+			want:     videoID.String() + "/1/audio.m4a",
+		},
+		{
+			name:     "date",
+			template: "{year}/{month}/{day}/{random}",
+			values:   values,
+			want:     now.Format("2006/01/02") + "/",
+		},
+		{
+			name:     "legacy version",
+			template: "{video_id}/{version}/{rendition}.{ext}",
+			values:   keyValues{VideoID: videoID, Rendition: renditionOriginal, Ext: ".mp4"},
+			want:     videoID.String() + "/0/original.mp4",
+		},
+		{
+			name:     "empty value",
+			template: "{orientation}/{random}.{ext}",
+			values:   keyValues{Ext: ".mp4"},
+			wantErr:  true,
+		},
+		{
+			name:     "value with a slash",
+			template: "{video_id}/{version}/{rendition}.{ext}",
+			values:   keyValues{VideoID: videoID, Rendition: "a/b", Ext: ".mp4"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := parseKeyTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("parseKeyTemplate(%q): %v", tt.template, err)
+			}
+			got, err := template.render(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if template.uses("random") {
+				// The date test's prefix is followed by the random part.
+				if len(got) != len(tt.want)+32 || got[:len(tt.want)] != tt.want {
+					t.Errorf("render() = %q, want %q followed by 32 hex digits", got, tt.want)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyTemplateRandom(t *testing.T) {
+	template, err := parseKeyTemplate(defaultVideoKeyTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := keyValues{Orientation: "portrait", Rendition: renditionVideo, Ext: ".mp4"}
+	first, err := template.render(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := template.render(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^portrait/[0-9a-f]{32}\.mp4$`).MatchString(first) {
+		t.Errorf("render() = %q, want portrait/<32 hex digits>.mp4", first)
+	}
+	if first == second {
+		t.Errorf("render() returned %q twice", first)
+	}
+}
+
+func TestKeyTemplateRenderSidecar(t *testing.T) {
+	userID := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	videoID := uuid.MustParse("22222222-2222-2222-2222-222222222222")
+
+	tests := []struct {
+		template string
+		videoID  uuid.UUID
+		kind     string
+		name     string
+		ext      string
+		want     string
+	}{
+		{
+			template: "{user_id}/{video_id}/{version}/{rendition}.{ext}",
+			videoID:  videoID,
+			kind:     kindCaptions,
+			name:     "pt-BR",
+			ext:      ".vtt",
+			want:     `^` + userID.String() + `/` + videoID.String() + `/0/captions-pt-BR-[0-9a-f]{16}\.vtt$`,
+		},
+		{
+			template: "{user_id}/{video_id}/{version}/{rendition}.{ext}",
+			videoID:  uuid.Nil,
+			kind:     kindWatermark,
+			ext:      ".png",
+			want:     `^` + userID.String() + `/` + uuid.Nil.String() + `/0/watermarks-[0-9a-f]{16}\.png$`,
+		},
+		{
+			template: defaultVideoKeyTemplate,
+			videoID:  videoID,
+			kind:     kindChapters,
+			ext:      ".vtt",
+			want:     `^chapters/[0-9a-f]{32}\.vtt$`,
+		},
+	}
+	for _, tt := range tests {
+		template, err := parseKeyTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := template.renderSidecar(userID, tt.videoID, tt.kind, tt.name, tt.ext)
+		if err != nil {
+			t.Fatalf("renderSidecar(%s) error: %v", tt.kind, err)
+		}
+		if !regexp.MustCompile(tt.want).MatchString(got) {
+			t.Errorf("renderSidecar(%s) = %q, want a match for %s", tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestKeyTemplateVideoPrefixDepth(t *testing.T) {
+	tests := []struct {
+		template string
+		want     int
+	}{
+		{"{user_id}/{video_id}/{version}/{rendition}.{ext}", 2},
+		{"v-{video_id}/{random}.{ext}", 1},
+		{"{orientation}/{user_id}/{video_id}/{random}.{ext}", 3},
+		{defaultVideoKeyTemplate, 0},
+		{"{orientation}/{video_id}-{version}-{rendition}.{ext}", 0},
+	}
+	for _, tt := range tests {
+		template, err := parseKeyTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := template.videoPrefixDepth(); got != tt.want {
+			t.Errorf("videoPrefixDepth(%q) = %d, want %d", tt.template, got, tt.want)
+		}
+	}
+}
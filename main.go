@@ -6,9 +6,16 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -20,13 +27,36 @@ type apiConfig struct {
 	filepathRoot         string
 	assetsRoot           string
 	s3Client             *s3.Client
+	videoStore           storage.Store
 	s3Bucket             string
 	s3Region             string
 	s3CfDistribution     string
 	CfDistributionDomain string
 	port                 string
+	publicBaseURL        string
+	aspectClasses        []aspectClass
+	videoKeyTemplate     keyTemplate
+	media                media.Runner
+	loudnessTargetLUFS   float64
+	uploadLimits         media.Limits
+	scratchDir           string
+	maxVideoVersions     int
+	adminAPIKey          string
+	duplicateScope       string
+	duplicateMaxDistance float64
+	cdnSigner            *cdn.Signer
+	signedURLTTL         time.Duration
+	signedCookieDomain   string
+	mediaURLMode         string
+	presignTTL           time.Duration
 }
 
+// Backends for STORAGE_BACKEND.
+const (
+	storageBackendS3    = "s3"
+	storageBackendLocal = "local"
+)
+
 type thumbnail struct {
 	data      []byte
 	mediaType string
@@ -65,31 +95,171 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = storageBackendS3
+	}
+	if storageBackend != storageBackendS3 && storageBackend != storageBackendLocal {
+		log.Fatalf("STORAGE_BACKEND must be %q or %q", storageBackendS3, storageBackendLocal)
+	}
+
 	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
+	if s3Bucket == "" && storageBackend == storageBackendS3 {
 		log.Fatal("S3_BUCKET environment variable is not set")
 	}
 
 	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
+	if s3Region == "" && storageBackend == storageBackendS3 {
 		log.Fatal("S3_REGION environment variable is not set")
 	}
 
 	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
+	if s3CfDistribution == "" && storageBackend == storageBackendS3 {
 		log.Fatal("S3_CF_DISTRO environment variable is not set")
 	}
 
 	cfDistributionDomain := os.Getenv("CLOUDFRONT_DOMAIN")
+	if cfDistributionDomain == "" {
+		cfDistributionDomain = s3CfDistribution
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	// publicBaseURL is where clients reach this server, for the URLs of
+	// assets and of media served through the API.
+	publicBaseURL := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if publicBaseURL == "" {
+		publicBaseURL = "http://localhost:" + port
+	}
+	if u, err := url.Parse(publicBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Fatal("PUBLIC_BASE_URL must be an absolute http or https URL")
+	}
+
+	aspectClasses := defaultAspectClasses()
+	if s := os.Getenv("VIDEO_ASPECT_CLASSES"); s != "" {
+		aspectClasses, err = parseAspectClasses(s)
+		if err != nil {
+			log.Fatalf("Invalid VIDEO_ASPECT_CLASSES: %v", err)
+		}
+	}
+
+	cacheRules := defaultCacheRules()
+	if s := os.Getenv("CACHE_POLICY"); s != "" {
+		cacheRules, err = parseCacheRules(s)
+		if err != nil {
+			log.Fatalf("Invalid CACHE_POLICY: %v", err)
+		}
+	}
+
+	videoKeyTemplateRaw := os.Getenv("VIDEO_KEY_TEMPLATE")
+	if videoKeyTemplateRaw == "" {
+		videoKeyTemplateRaw = defaultVideoKeyTemplate
+	}
+	videoKeyTemplate, err := parseKeyTemplate(videoKeyTemplateRaw)
+	if err != nil {
+		log.Fatalf("Invalid VIDEO_KEY_TEMPLATE: %v", err)
+	}
+
+	mediaRunner := media.NewExecRunner(media.ExecConfig{
+		FFmpegPath:     os.Getenv("FFMPEG_PATH"),
+		FFprobePath:    os.Getenv("FFPROBE_PATH"),
+		Timeout:        envDuration("FFMPEG_TIMEOUT", 10*time.Minute),
+		MaxConcurrent:  envInt("FFMPEG_MAX_JOBS", 2),
+		Nice:           envInt("FFMPEG_NICE", 0),
+		MaxMemoryBytes: int64(envInt("FFMPEG_MAX_MEMORY_MB", 0)) << 20,
+		MaxCPUSeconds:  envInt("FFMPEG_MAX_CPU_SECONDS", 0),
+	})
+
+	loudnessTargetLUFS := envFloat("LOUDNESS_TARGET_LUFS", -16)
+	if err := validateLoudnessTarget(loudnessTargetLUFS); err != nil {
+		log.Fatalf("Invalid LOUDNESS_TARGET_LUFS: %v", err)
+	}
+
+	uploadLimits := media.Limits{
+		MaxDuration:  envDuration("VIDEO_MAX_DURATION", 2*time.Hour),
+		MaxWidth:     envInt("VIDEO_MAX_WIDTH", 3840),
+		MaxHeight:    envInt("VIDEO_MAX_HEIGHT", 2160),
+		MaxBitRate:   int64(envInt("VIDEO_MAX_BITRATE_KBPS", 50000)) * 1000,
+		MaxFrameRate: envFloat("VIDEO_MAX_FPS", 60),
+	}
+
+	maxVideoVersions := envInt("VIDEO_MAX_VERSIONS", 5)
+	if maxVideoVersions < 0 {
+		log.Fatal("VIDEO_MAX_VERSIONS must not be negative")
+	}
+
+	duplicateScope := os.Getenv("DUPLICATE_SCOPE")
+	if duplicateScope == "" {
+		duplicateScope = duplicateScopeUser
+	}
+	if duplicateScope != duplicateScopeUser && duplicateScope != duplicateScopeInstance {
+		log.Fatalf("DUPLICATE_SCOPE must be %q or %q", duplicateScopeUser, duplicateScopeInstance)
+	}
+	duplicateMaxDistance := envFloat("DUPLICATE_MAX_DISTANCE", 6)
+
+	var cdnSigner *cdn.Signer
+	if keyPairID := os.Getenv("CLOUDFRONT_KEY_PAIR_ID"); keyPairID != "" {
+		keyPEM, err := os.ReadFile(os.Getenv("CLOUDFRONT_PRIVATE_KEY_PATH"))
+		if err != nil {
+			log.Fatalf("Couldn't read CLOUDFRONT_PRIVATE_KEY_PATH: %v", err)
+		}
+		cdnSigner, err = cdn.NewSigner(keyPairID, keyPEM)
+		if err != nil {
+			log.Fatalf("Invalid CloudFront signing key: %v", err)
+		}
+		if err := checkSigner(cdnSigner, cfDistributionDomain); err != nil {
+			log.Fatalf("CloudFront signing key failed its self-check: %v", err)
+		}
+	}
+	signedURLTTL := envDuration("CLOUDFRONT_URL_TTL", time.Hour)
+	if signedURLTTL <= 0 {
+		log.Fatal("CLOUDFRONT_URL_TTL must be positive")
+	}
+
+	mediaURLMode := os.Getenv("MEDIA_URL_MODE")
+	if mediaURLMode == "" {
+		mediaURLMode = mediaURLModeCloudFront
+		if storageBackend == storageBackendLocal {
+			mediaURLMode = mediaURLModeAPI
+		}
+	}
+	if mediaURLMode != mediaURLModeCloudFront && mediaURLMode != mediaURLModePresigned && mediaURLMode != mediaURLModeAPI {
+		log.Fatalf("MEDIA_URL_MODE must be %q, %q or %q", mediaURLModeCloudFront, mediaURLModePresigned, mediaURLModeAPI)
+	}
+	if mediaURLMode == mediaURLModeCloudFront && cdnSigner == nil {
+		restricted, err := db.CountRestrictedVideos()
+		if err != nil {
+			log.Fatalf("Couldn't count unlisted and private videos: %v", err)
+		}
+		if restricted > 0 {
+			log.Fatalf("CLOUDFRONT_KEY_PAIR_ID must be set: %d unlisted or private videos need signed URLs", restricted)
+		}
+		log.Print("CLOUDFRONT_KEY_PAIR_ID isn't set: unlisted and private videos won't have media URLs")
+	}
+	presignTTL := envDuration("S3_PRESIGN_TTL", 15*time.Minute)
+	// S3 rejects presigned URLs valid for longer than a week.
+	if presignTTL <= 0 || presignTTL > 7*24*time.Hour {
+		log.Fatal("S3_PRESIGN_TTL must be positive and at most 168h")
+	}
+
 	s3cfg, err := awsconfig.LoadDefaultConfig(context.TODO(), awsconfig.WithDefaultRegion(s3Region))
 	s3Client := s3.NewFromConfig(s3cfg)
 
+	var videoStore storage.Store = storage.NewS3(s3Client, s3Bucket)
+	if storageBackend == storageBackendLocal {
+		localStorageDir := os.Getenv("LOCAL_STORAGE_DIR")
+		if localStorageDir == "" {
+			localStorageDir = "./storage"
+		}
+		videoStore, err = storage.NewLocal(localStorageDir)
+		if err != nil {
+			log.Fatalf("Couldn't create local storage directory: %v", err)
+		}
+	}
+
 	cfg := apiConfig{
 		db:                   db,
 		jwtSecret:            jwtSecret,
@@ -97,11 +267,32 @@ func main() {
 		filepathRoot:         filepathRoot,
 		assetsRoot:           assetsRoot,
 		s3Client:             s3Client,
+		videoStore:           videoStore,
 		s3Bucket:             s3Bucket,
 		s3Region:             s3Region,
 		s3CfDistribution:     s3CfDistribution,
 		CfDistributionDomain: cfDistributionDomain,
 		port:                 port,
+		publicBaseURL:        publicBaseURL,
+		aspectClasses:        aspectClasses,
+		videoKeyTemplate:     videoKeyTemplate,
+		media:                mediaRunner,
+		loudnessTargetLUFS:   loudnessTargetLUFS,
+		uploadLimits:         uploadLimits,
+		scratchDir:           os.Getenv("UPLOAD_SCRATCH_DIR"),
+		maxVideoVersions:     maxVideoVersions,
+		adminAPIKey:          os.Getenv("ADMIN_API_KEY"),
+		duplicateScope:       duplicateScope,
+		duplicateMaxDistance: duplicateMaxDistance,
+		cdnSigner:            cdnSigner,
+		signedURLTTL:         signedURLTTL,
+		signedCookieDomain:   os.Getenv("CLOUDFRONT_COOKIE_DOMAIN"),
+		mediaURLMode:         mediaURLMode,
+		presignTTL:           presignTTL,
+	}
+
+	if _, ok := cfg.videoStore.(storage.Presigner); mediaURLMode == mediaURLModePresigned && !ok {
+		log.Fatal("MEDIA_URL_MODE=presigned needs a video store that can presign URLs")
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -114,28 +305,93 @@ func main() {
 	mux.Handle("/app/", appHandler)
 
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.Handle("/assets/", assetsHandler)
+	mux.Handle("/assets/thumbnails/", cfg.publicThumbnailsOnly(assetsHandler))
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("GET /api/users/me", cfg.handlerUserMeGet)
+	mux.HandleFunc("PATCH /api/users/me", cfg.handlerUserMeUpdate)
+	mux.HandleFunc("POST /api/users/me/avatar", cfg.handlerAvatarUpload)
+	mux.HandleFunc("DELETE /api/users/me/avatar", cfg.handlerAvatarDelete)
+	mux.HandleFunc("GET /api/users/me/processing", cfg.handlerProcessingSettingsGet)
+	mux.HandleFunc("PUT /api/users/me/processing", cfg.handlerProcessingSettingsUpdate)
+	mux.HandleFunc("POST /api/users/me/watermark", cfg.handlerWatermarkUpload)
+	mux.HandleFunc("DELETE /api/users/me/watermark", cfg.handlerWatermarkDelete)
+	mux.HandleFunc("GET /api/users/{handle}", cfg.handlerUserProfileGet)
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail/from-frame", cfg.handlerThumbnailFromFrame)
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail/crop", cfg.handlerThumbnailCrop)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/videos/{videoID}/reprocess", cfg.handlerVideoReprocess)
+	mux.HandleFunc("POST /api/videos/{videoID}/clips", cfg.handlerClipCreate)
+	mux.HandleFunc("GET /api/videos/{videoID}/captions", cfg.handlerCaptionsList)
+	mux.HandleFunc("POST /api/videos/{videoID}/captions/{language}", cfg.handlerCaptionsCreate)
+	mux.HandleFunc("PUT /api/videos/{videoID}/captions/{language}", cfg.handlerCaptionsReplace)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/captions/{language}", cfg.handlerCaptionsDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/stream", cfg.handlerVideoStream)
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnail", cfg.handlerVideoThumbnail)
+	mux.HandleFunc("GET /api/videos/{videoID}/playback-cookies", cfg.handlerPlaybackCookies)
+	mux.HandleFunc("GET /api/videos/{videoID}/chapters", cfg.handlerChaptersGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}/chapters", cfg.handlerChaptersUpdate)
+	mux.HandleFunc("GET /api/videos/{videoID}/versions", cfg.handlerVideoVersionsList)
+	mux.HandleFunc("POST /api/videos/{videoID}/versions/{version}/restore", cfg.handlerVideoVersionRestore)
+	mux.HandleFunc("GET /api/videos/{videoID}/qc", cfg.handlerQualityReportGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}/visibility", cfg.handlerVideoVisibilityUpdate)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/public", cfg.handlerVideosPublic)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("GET /admin/duplicates", cfg.handlerAdminDuplicates)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: cacheMiddleware(cacheRules, mux),
 	}
 
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
 	log.Fatal(srv.ListenAndServe())
 }
+
+func envInt(name string, fallback int) int {
+	s := os.Getenv(name)
+	if s == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", name, err)
+	}
+	return n
+}
+
+func envFloat(name string, fallback float64) float64 {
+	s := os.Getenv(name)
+	if s == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		log.Fatalf("%s must be a number: %v", name, err)
+	}
+	return f
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	s := os.Getenv(name)
+	if s == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Fatalf("%s must be a duration such as 90s or 10m: %v", name, err)
+	}
+	return d
+}
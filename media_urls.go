@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Modes for MEDIA_URL_MODE: how viewers are given URLs for stored media.
+const (
+	// mediaURLModeCloudFront serves media from the CloudFront distribution,
+	// with signed URLs for videos that aren't public.
+	mediaURLModeCloudFront = "cloudfront"
+	// mediaURLModePresigned serves media straight from a private bucket
+	// with short-lived presigned URLs.
+	mediaURLModePresigned = "presigned"
+	// mediaURLModeAPI serves media through this server's stream and
+	// thumbnail endpoints, for running without a CDN, e.g. with local
+	// storage.
+	mediaURLModeAPI = "api"
+)
+
+// mediaURL returns the URL a viewer of video should fetch key from right
+// now. CloudFront URLs are signed unless the video is public; presigned
+// URLs always are. It returns "" if the URL can't be signed, so without a
+// CloudFront signer only public videos have media URLs.
+func (cfg *apiConfig) mediaURL(ctx context.Context, video database.Video, key string) string {
+	switch cfg.mediaURLMode {
+	case mediaURLModeAPI:
+		return cfg.streamURL(video, key)
+	case mediaURLModePresigned:
+		presigner, ok := cfg.videoStore.(storage.Presigner)
+		if !ok {
+			log.Printf("Video store can't presign %s", key)
+			return ""
+		}
+		u, err := presigner.PresignGet(ctx, key, cfg.presignTTL)
+		if err != nil {
+			log.Printf("Couldn't presign %s: %v", key, err)
+			return ""
+		}
+		return u
+	}
+	if !needsSigning(video) {
+		return cfg.objectURL(key)
+	}
+	return cfg.signURL(cfg.objectURL(key))
+}
+
+// streamURL is the stream endpoint URL serving key, one of video's files.
+func (cfg *apiConfig) streamURL(video database.Video, key string) string {
+	u := cfg.publicURL(fmt.Sprintf("/api/videos/%s/stream", video.ID))
+	if video.VideoKey != nil && *video.VideoKey == key {
+		return u
+	}
+	return u + "?key=" + url.QueryEscape(key)
+}
+
+// storedURL is the URL saved alongside a key in the videos table. Only
+// keys are stored outside CloudFront mode, since other URLs expire or
+// depend on the viewer; either way, responses build URLs from keys, so
+// switching modes needs no migration.
+func (cfg *apiConfig) storedURL(key string) *string {
+	if cfg.mediaURLMode != mediaURLModeCloudFront {
+		return nil
+	}
+	u := cfg.objectURL(key)
+	return &u
+}
+
+// keyFromURL recovers the storage key from a URL saved by an older version
+// that stored URLs but not keys: either a CloudFront URL or one of the
+// forms of S3 URL. It returns "" for URLs it doesn't recognise.
+func (cfg *apiConfig) keyFromURL(rawURL string) string {
+	prefixes := []string{
+		cfg.objectURL(""),
+		fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", cfg.s3Bucket, cfg.s3Region),
+		fmt.Sprintf("https://%s.s3.amazonaws.com/", cfg.s3Bucket),
+		fmt.Sprintf("https://s3.%s.amazonaws.com/%s/", cfg.s3Region, cfg.s3Bucket),
+	}
+	rawURL, _, _ = strings.Cut(rawURL, "?")
+	for _, prefix := range prefixes {
+		if rest, ok := strings.CutPrefix(rawURL, prefix); ok && rest != "" {
+			key, err := url.PathUnescape(rest)
+			if err != nil {
+				return ""
+			}
+			return key
+		}
+	}
+	return ""
+}
+
+// resolveURL returns the URL to give viewers of video for media stored
+// under key, falling back to the key in storedURL for rows saved before
+// keys were, and to storedURL itself if there's no key to be found.
+func (cfg *apiConfig) resolveURL(ctx context.Context, video database.Video, key *string, storedURL *string) *string {
+	k := ""
+	if key != nil {
+		k = *key
+	} else if storedURL != nil {
+		k = cfg.keyFromURL(*storedURL)
+	}
+	if k == "" {
+		if storedURL == nil || cfg.mediaURLMode != mediaURLModeCloudFront {
+			return nil
+		}
+		u := *storedURL
+		if needsSigning(video) {
+			u = cfg.signURL(u)
+		}
+		if u == "" {
+			return nil
+		}
+		return &u
+	}
+	u := cfg.mediaURL(ctx, video, k)
+	if u == "" {
+		return nil
+	}
+	return &u
+}
+
+// resolveMediaURLs fills in the media URLs of a video about to be returned
+// to a viewer allowed to watch it. URLs are replaced rather than written
+// through, as video shares its pointers and slices with the caller's copy.
+func (cfg *apiConfig) resolveMediaURLs(ctx context.Context, video *database.Video) {
+	v := *video
+	video.VideoURL = cfg.resolveURL(ctx, v, v.VideoKey, v.VideoURL)
+	video.ChaptersURL = cfg.resolveURL(ctx, v, v.ChaptersKey, v.ChaptersURL)
+	video.Captions = slices.Clone(v.Captions)
+	for i := range video.Captions {
+		video.Captions[i].URL = cfg.mediaURL(ctx, v, video.Captions[i].Key)
+	}
+	video.AudioRenditions = slices.Clone(v.AudioRenditions)
+	for i := range video.AudioRenditions {
+		video.AudioRenditions[i].URL = cfg.mediaURL(ctx, v, video.AudioRenditions[i].Key)
+	}
+	// Rendered thumbnails are only served from /assets/ for public videos;
+	// others go through the thumbnail endpoint and its visibility check.
+	if (cfg.mediaURLMode == mediaURLModeAPI || needsSigning(v)) && len(v.Thumbnails) > 0 {
+		base := cfg.publicURL(fmt.Sprintf("/api/videos/%s/thumbnail", v.ID))
+		video.ThumbnailURL = &base
+		video.Thumbnails = slices.Clone(v.Thumbnails)
+		for i, t := range video.Thumbnails {
+			video.Thumbnails[i].URL = fmt.Sprintf("%s?width=%d&format=%s", base, t.Width, t.Format)
+		}
+	}
+}
+
+// needsSigning reports whether a video's media must only be reachable
+// through signed URLs: everything but public videos.
+func needsSigning(video database.Video) bool {
+	return video.Visibility != database.VisibilityPublic
+}
+
+// respondWithVideo responds with video, its media URLs resolved and its
+// owner-only fields hidden for the viewer.
+func (cfg *apiConfig) respondWithVideo(w http.ResponseWriter, r *http.Request, code int, video database.Video) {
+	cfg.prepareVideo(r, &video)
+	respondWithJSON(w, code, video)
+}
+
+func (cfg *apiConfig) respondWithVideos(w http.ResponseWriter, r *http.Request, code int, videos []database.Video) {
+	for i := range videos {
+		cfg.prepareVideo(r, &videos[i])
+	}
+	respondWithJSON(w, code, videos)
+}
+
+func (cfg *apiConfig) prepareVideo(r *http.Request, video *database.Video) {
+	cfg.resolveMediaURLs(r.Context(), video)
+	if cfg.isAdmin(r) {
+		return
+	}
+	viewer, _ := cfg.optionalViewer(r)
+	cfg.hideOwnerFields(video, viewer)
+}
+
+// hideOwnerFields clears what only the video's owner may see: details of
+// the latest upload and its checks. With DUPLICATE_SCOPE=instance the
+// video a duplicate was matched with may be someone else's, so even the
+// owner only sees its ID if it is theirs too.
+func (cfg *apiConfig) hideOwnerFields(video *database.Video, viewer uuid.UUID) {
+	if video.UserID != viewer {
+		video.SourceSHA256 = nil
+		video.QCVerdict = nil
+		video.DuplicateOfID = nil
+		video.DuplicateDistance = nil
+		video.CurrentVersionID = nil
+		return
+	}
+	if video.DuplicateOfID != nil {
+		other, err := cfg.db.GetVideo(*video.DuplicateOfID)
+		if err != nil || other.UserID != viewer {
+			video.DuplicateOfID = nil
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// signURL signs a URL on the CloudFront distribution so it works for
+// signedURLTTL. Other URLs, such as local assets, are returned as they
+// are. It returns "" for a CloudFront URL it can't sign, including when no
+// signer is configured, as handing it out unsigned would make it work
+// forever.
+func (cfg *apiConfig) signURL(rawURL string) string {
+	if !strings.HasPrefix(rawURL, cfg.objectURL("")) {
+		return rawURL
+	}
+	if cfg.cdnSigner == nil {
+		return ""
+	}
+	signed, err := cfg.cdnSigner.SignURL(rawURL, time.Now().Add(cfg.signedURLTTL))
+	if err != nil {
+		log.Printf("Couldn't sign %s: %v", rawURL, err)
+		return ""
+	}
+	return signed
+}
+
+// handlerPlaybackCookies sets CloudFront signed cookies covering every
+// file stored in the video's directories, for players such as HLS that
+// fetch many URLs which can't each be signed. The key template must give
+// each video directories of its own, or the cookies would open other
+// videos. Files of different kinds may be laid out under different
+// directories, e.g. by {orientation}, so each gets its own set of cookies,
+// scoped by path.
+func (cfg *apiConfig) handlerPlaybackCookies(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.viewableVideo(w, r)
+	if !ok {
+		return
+	}
+	if cfg.cdnSigner == nil || cfg.mediaURLMode != mediaURLModeCloudFront {
+		respondWithError(w, http.StatusNotFound, "Signed cookies aren't enabled", nil)
+		return
+	}
+	if video.VideoKey == nil {
+		respondWithError(w, http.StatusConflict, "Video has no stored file", nil)
+		return
+	}
+	prefixes, ok := cfg.videoKeyPrefixes(video)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Video's files aren't stored in a directory of their own, so signed cookies can't be issued for them", nil)
+		return
+	}
+
+	expires := time.Now().Add(cfg.signedURLTTL)
+	for _, prefix := range prefixes {
+		cookies, err := cfg.cdnSigner.SignedCookies(cdn.Policy{
+			Resource: cfg.objectURL(prefix + "*"),
+			Expires:  expires,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign cookies", err)
+			return
+		}
+		for _, c := range cookies {
+			c.Domain = cfg.signedCookieDomain
+			c.Path = "/" + prefix
+			c.Expires = expires
+			c.Secure = true
+			c.HttpOnly = true
+			c.SameSite = http.SameSiteNoneMode
+			http.SetCookie(w, c)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// videoKeyPrefixes returns the directories, with trailing slashes, that
+// hold the files a player loads for video, its processed file, audio
+// renditions and caption and chapter tracks, and no other video's files.
+// It returns false if the processed file isn't in such a directory, as
+// happens when the key template doesn't name the video in a directory or
+// the file was stored under a template laid out differently. Other files
+// that aren't are left out, and need signed URLs.
+func (cfg *apiConfig) videoKeyPrefixes(video database.Video) ([]string, bool) {
+	if video.VideoKey == nil {
+		return nil, false
+	}
+	prefix, ok := cfg.videoKeyPrefix(video, *video.VideoKey)
+	if !ok {
+		return nil, false
+	}
+	prefixes := []string{prefix}
+
+	var keys []string
+	if video.ChaptersKey != nil {
+		keys = append(keys, *video.ChaptersKey)
+	}
+	for _, rendition := range video.AudioRenditions {
+		keys = append(keys, rendition.Key)
+	}
+	for _, track := range video.Captions {
+		keys = append(keys, track.Key)
+	}
+	for _, key := range keys {
+		if prefix, ok := cfg.videoKeyPrefix(video, key); ok && !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes, true
+}
+
+// videoKeyPrefix returns the directory of one of the video's stored files
+// that holds no other video's files, with a trailing slash.
+func (cfg *apiConfig) videoKeyPrefix(video database.Video, key string) (string, bool) {
+	depth := cfg.videoKeyTemplate.videoPrefixDepth()
+	if depth == 0 {
+		return "", false
+	}
+	segments := strings.Split(key, "/")
+	if len(segments) <= depth || !strings.Contains(segments[depth-1], video.ID.String()) {
+		return "", false
+	}
+	return strings.Join(segments[:depth], "/") + "/", true
+}
+
+// checkSigner signs a URL and verifies it with the signer's public key, so
+// a misconfigured key fails at startup rather than on every playback.
+func checkSigner(signer *cdn.Signer, domain string) error {
+	probe := fmt.Sprintf("https://%s/signer-check?x=1&y=2", domain)
+	now := time.Now()
+	signed, err := signer.SignURL(probe, now.Add(time.Minute))
+	if err != nil {
+		return err
+	}
+	if err := cdn.VerifyURL(signer.PublicKey(), signed, now); err != nil {
+		return fmt.Errorf("canned policy: %w", err)
+	}
+	signed, err = signer.SignURLWithPolicy(probe, cdn.Policy{Resource: probe, Expires: now.Add(time.Minute)})
+	if err != nil {
+		return err
+	}
+	if err := cdn.VerifyURL(signer.PublicKey(), signed, now); err != nil {
+		return fmt.Errorf("custom policy: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// timestamp is a position in a video, in seconds. In JSON it may be given
+// as a number of seconds or as a string such as "42.5", "0:42" or
+// "1:02:03.5".
+type timestamp float64
+
+func (t *timestamp) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		return t.set(seconds)
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("timestamp must be a number of seconds or a string like 1:02:03.5")
+	}
+	seconds, err := parseTimestamp(s)
+	if err != nil {
+		return err
+	}
+	return t.set(seconds)
+}
+
+func (t *timestamp) set(seconds float64) error {
+	if seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return fmt.Errorf("timestamp must not be negative")
+	}
+	*t = timestamp(seconds)
+	return nil
+}
+
+// parseTimestamp parses "[[hh:]mm:]ss[.fff]" into seconds.
+func parseTimestamp(s string) (float64, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	var seconds float64
+	for i, part := range parts {
+		last := i == len(parts)-1
+		var v float64
+		var err error
+		if last {
+			v, err = strconv.ParseFloat(part, 64)
+		} else {
+			var n int
+			n, err = strconv.Atoi(part)
+			v = float64(n)
+		}
+		if err != nil || v < 0 || (i > 0 && v >= 60) {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+const (
+	minLoudnessTargetLUFS = -70.0
+	maxLoudnessTargetLUFS = -5.0
+)
+
+// processingOptions selects the optional steps of the video pipeline.
+type processingOptions struct {
+	NormalizeLoudness  bool
+	LoudnessTargetLUFS float64
+	// Watermark is nil when no watermark should be applied.
+	Watermark *database.WatermarkSettings
+	// AudioFormats lists the audio-only renditions to publish alongside
+	// the video.
+	AudioFormats []string
+	// Version is the number of the video version being published, for
+	// the {version} key placeholder. processingOptionsForUpload leaves it
+	// to the caller.
+	Version int
+}
+
+// optionError reports an override sent with an upload that can't be
+// applied. Its message is meant for the client.
+type optionError struct {
+	msg string
+}
+
+func (e *optionError) Error() string {
+	return e.msg
+}
+
+// processingOptionsForUpload starts from the user's saved defaults and
+// applies any overrides sent as form fields with the upload. Invalid
+// overrides are reported as an *optionError.
+func (cfg *apiConfig) processingOptionsForUpload(form url.Values, userID uuid.UUID) (processingOptions, error) {
+	settings, err := cfg.db.GetProcessingSettings(userID)
+	if err != nil {
+		return processingOptions{}, fmt.Errorf("couldn't get processing settings: %w", err)
+	}
+	opts := processingOptions{
+		NormalizeLoudness:  settings.NormalizeLoudness,
+		LoudnessTargetLUFS: cfg.loudnessTargetLUFS,
+	}
+	if settings.LoudnessTargetLUFS != nil {
+		opts.LoudnessTargetLUFS = *settings.LoudnessTargetLUFS
+	}
+	if settings.Watermark.HasImage {
+		opts.Watermark = &settings.Watermark
+	}
+	opts.AudioFormats = settings.AudioRenditions
+
+	if s := form.Get("normalize_loudness"); s != "" {
+		opts.NormalizeLoudness, err = strconv.ParseBool(s)
+		if err != nil {
+			return processingOptions{}, &optionError{"normalize_loudness must be true or false"}
+		}
+	}
+	if s := form.Get("loudness_target_lufs"); s != "" {
+		opts.LoudnessTargetLUFS, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return processingOptions{}, &optionError{"loudness_target_lufs must be a number"}
+		}
+	}
+	if s := form.Get("watermark"); s != "" {
+		apply, err := strconv.ParseBool(s)
+		if err != nil {
+			return processingOptions{}, &optionError{"watermark must be true or false"}
+		}
+		if apply && opts.Watermark == nil {
+			return processingOptions{}, &optionError{"no watermark image has been uploaded"}
+		}
+		if !apply {
+			opts.Watermark = nil
+		}
+	}
+	if s := form.Get("audio_renditions"); s != "" {
+		opts.AudioFormats = nil
+		if s != "none" {
+			opts.AudioFormats = strings.Split(s, ",")
+		}
+	}
+	opts.AudioFormats, err = normalizeAudioFormats(opts.AudioFormats)
+	if err != nil {
+		return processingOptions{}, &optionError{err.Error()}
+	}
+	if err := validateLoudnessTarget(opts.LoudnessTargetLUFS); err != nil {
+		return processingOptions{}, &optionError{err.Error()}
+	}
+	return opts, nil
+}
+
+// respondWithOptionsError reports a processingOptionsForUpload failure:
+// the client's mistake for an invalid override, ours otherwise.
+func respondWithOptionsError(w http.ResponseWriter, err error) {
+	var optErr *optionError
+	if errors.As(err, &optErr) {
+		respondWithError(w, http.StatusBadRequest, optErr.msg, nil)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldn't load processing settings", err)
+}
+
+// normalizeAudioFormats trims, lowercases and de-duplicates a list of audio
+// rendition formats, rejecting unsupported ones.
+func normalizeAudioFormats(formats []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, format := range formats {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" || seen[format] {
+			continue
+		}
+		if media.AudioMediaType(format) == "" {
+			return nil, fmt.Errorf("unsupported audio rendition %q, use m4a or mp3", format)
+		}
+		seen[format] = true
+		normalized = append(normalized, format)
+	}
+	return normalized, nil
+}
+
+func validateLoudnessTarget(lufs float64) error {
+	if lufs < minLoudnessTargetLUFS || lufs > maxLoudnessTargetLUFS {
+		return fmt.Errorf("loudness target must be between %.0f and %.0f LUFS", minLoudnessTargetLUFS, maxLoudnessTargetLUFS)
+	}
+	return nil
+}
+
+// processVideo runs the enabled processing steps followed by the fast-start
+// remux on the file at inputPath, recording their results on video. It
+// returns the path of the file to publish, which the caller must remove
+// unless it is inputPath itself: a file that needs no processing and
+// already starts with its moov atom is published as-is. The input file is
+// never modified.
+func (cfg *apiConfig) processVideo(ctx context.Context, video *database.Video, inputPath string, probe media.ProbeResult, opts processingOptions) (string, error) {
+	current := inputPath
+	var intermediates []string
+	defer func() {
+		for _, path := range intermediates {
+			os.Remove(path)
+		}
+	}()
+
+	if opts.Watermark != nil {
+		stream, err := probe.VideoStream()
+		if err != nil {
+			return "", err
+		}
+		imagePath, err := cfg.downloadToTemp(ctx, *opts.Watermark.Key, "watermark-*.png")
+		if err != nil {
+			return "", fmt.Errorf("couldn't download watermark: %w", err)
+		}
+		intermediates = append(intermediates, imagePath)
+
+		watermarkedPath := inputPath + ".watermark"
+		err = media.ApplyWatermark(ctx, cfg.media, current, watermarkedPath, media.Overlay{
+			ImagePath: imagePath,
+			Position:  opts.Watermark.Position,
+			Margin:    opts.Watermark.Margin,
+			Width:     max(1, int(float64(stream.Width)*opts.Watermark.Scale)),
+			Opacity:   opts.Watermark.Opacity,
+		})
+		if err != nil {
+			return "", fmt.Errorf("couldn't apply watermark: %w", err)
+		}
+		intermediates = append(intermediates, watermarkedPath)
+		current = watermarkedPath
+	}
+
+	video.LoudnessMeasuredLUFS = nil
+	video.LoudnessTargetLUFS = nil
+	if opts.NormalizeLoudness && probe.HasAudio() {
+		stats, err := media.MeasureLoudness(ctx, cfg.media, current, opts.LoudnessTargetLUFS)
+		switch {
+		case errors.Is(err, media.ErrSilentAudio):
+			// Nothing to normalize; publish the audio as-is.
+		case err != nil:
+			return "", fmt.Errorf("couldn't measure loudness: %w", err)
+		default:
+			normalizedPath := inputPath + ".loudnorm"
+			err = media.NormalizeLoudness(ctx, cfg.media, current, normalizedPath, opts.LoudnessTargetLUFS, stats)
+			if err != nil {
+				return "", fmt.Errorf("couldn't normalize loudness: %w", err)
+			}
+			intermediates = append(intermediates, normalizedPath)
+			current = normalizedPath
+
+			measured, target := stats.IntegratedLUFS, opts.LoudnessTargetLUFS
+			video.LoudnessMeasuredLUFS = &measured
+			video.LoudnessTargetLUFS = &target
+		}
+	}
+
+	if chapters := mediaChapters(video); len(chapters) > 0 {
+		chaptersPath := inputPath + ".chapters"
+		err := media.EmbedChapters(ctx, cfg.media, current, chaptersPath, chapters, *video.DurationSeconds)
+		if err != nil {
+			return "", fmt.Errorf("couldn't embed chapters: %w", err)
+		}
+		intermediates = append(intermediates, chaptersPath)
+		current = chaptersPath
+	}
+
+	fastStart, err := media.IsFastStart(current)
+	if err != nil {
+		return "", fmt.Errorf("couldn't inspect video layout: %w", err)
+	}
+	if fastStart {
+		if current != inputPath {
+			// Hand the last step's output to the caller instead of removing it.
+			intermediates = intermediates[:len(intermediates)-1]
+		}
+		return current, nil
+	}
+
+	outputPath := inputPath + ".faststart"
+	err = media.FastStart(ctx, cfg.media, current, outputPath)
+	if err != nil {
+		return "", fmt.Errorf("couldn't process video for fast start: %w", err)
+	}
+	return outputPath, nil
+}
+
+// storeOriginal uploads the unprocessed file at path as the video's
+// original so it can be processed again later, e.g. after the owner changes
+// their watermark.
+func (cfg *apiConfig) storeOriginal(ctx context.Context, video *database.Video, path string, probe media.ProbeResult, version int) error {
+	stream, err := probe.VideoStream()
+	if err != nil {
+		return err
+	}
+	key, err := cfg.videoKeyTemplate.render(keyValues{
+		UserID:      video.UserID,
+		VideoID:     video.ID,
+		Version:     version,
+		Orientation: classifyAspect(cfg.aspectClasses, stream.Width, stream.Height),
+		Rendition:   renditionOriginal,
+		Ext:         ".mp4",
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't build storage key: %w", err)
+	}
+	err = cfg.putFile(ctx, key, path, "video/mp4")
+	if err != nil {
+		return fmt.Errorf("couldn't upload original video: %w", err)
+	}
+	video.SourceKey = &key
+	return nil
+}
+
+// publishVideo processes the file at sourcePath, uploads the result and
+// points video at it. The caller is responsible for saving video.
+func (cfg *apiConfig) publishVideo(ctx context.Context, video *database.Video, sourcePath string, probe media.ProbeResult, opts processingOptions) error {
+	stream, err := probe.VideoStream()
+	if err != nil {
+		return err
+	}
+
+	duration := probe.Duration()
+	video.DurationSeconds = &duration
+
+	processedPath, err := cfg.processVideo(ctx, video, sourcePath, probe, opts)
+	if err != nil {
+		return err
+	}
+	if processedPath != sourcePath {
+		defer os.Remove(processedPath)
+	}
+
+	key, err := cfg.videoKeyTemplate.render(keyValues{
+		UserID:      video.UserID,
+		VideoID:     video.ID,
+		Version:     opts.Version,
+		Orientation: classifyAspect(cfg.aspectClasses, stream.Width, stream.Height),
+		Rendition:   renditionVideo,
+		Ext:         ".mp4",
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't build storage key: %w", err)
+	}
+
+	err = cfg.putFile(ctx, key, processedPath, "video/mp4")
+	if err != nil {
+		return fmt.Errorf("couldn't upload processed video: %w", err)
+	}
+
+	video.VideoKey = &key
+	video.VideoURL = cfg.storedURL(key)
+
+	// The new upload may be shorter, which trims or drops the last chapters.
+	if len(video.Chapters) > 0 || video.ChaptersKey != nil {
+		if err := cfg.publishChapterTrack(ctx, video); err != nil {
+			return fmt.Errorf("couldn't publish chapters track: %w", err)
+		}
+	}
+
+	return cfg.publishAudioRenditions(ctx, video, processedPath, probe, opts)
+}
+
+// publishAudioRenditions extracts the requested audio-only renditions from
+// the processed video and stages them on video in place of the ones it
+// had. They are saved along with video by PublishVideo, after which the
+// caller deletes the files of the replaced ones.
+func (cfg *apiConfig) publishAudioRenditions(ctx context.Context, video *database.Video, processedPath string, probe media.ProbeResult, opts processingOptions) error {
+	renditions := []database.AudioRendition{}
+	if probe.HasAudio() {
+		for _, format := range opts.AudioFormats {
+			rendition, err := cfg.publishAudioRendition(ctx, video, processedPath, format, opts.Version)
+			if err != nil {
+				cfg.deleteAudioRenditions(ctx, *video, renditions)
+				return err
+			}
+			renditions = append(renditions, rendition)
+		}
+	}
+	video.AudioRenditions = renditions
+	return nil
+}
+
+// deleteAudioRenditions deletes the files of renditions that were uploaded
+// but won't be staged, other than those whose keys the video's renditions
+// already had.
+func (cfg *apiConfig) deleteAudioRenditions(ctx context.Context, video database.Video, renditions []database.AudioRendition) {
+	inUse := map[string]bool{}
+	for _, rendition := range video.AudioRenditions {
+		inUse[rendition.Key] = true
+	}
+	for _, rendition := range renditions {
+		if inUse[rendition.Key] {
+			continue
+		}
+		if err := cfg.videoStore.Delete(ctx, rendition.Key); err != nil {
+			log.Printf("Couldn't delete audio rendition %s: %v", rendition.Key, err)
+		}
+	}
+}
+
+func (cfg *apiConfig) publishAudioRendition(ctx context.Context, video *database.Video, processedPath, format string, version int) (database.AudioRendition, error) {
+	audioPath := processedPath + "." + format
+	err := media.ExtractAudio(ctx, cfg.media, processedPath, audioPath, format)
+	if err != nil {
+		return database.AudioRendition{}, fmt.Errorf("couldn't extract %s audio: %w", format, err)
+	}
+	defer os.Remove(audioPath)
+
+	audioProbe, err := media.Probe(ctx, cfg.media, audioPath)
+	if err != nil {
+		return database.AudioRendition{}, fmt.Errorf("couldn't probe %s audio: %w", format, err)
+	}
+	info, err := os.Stat(audioPath)
+	if err != nil {
+		return database.AudioRendition{}, err
+	}
+
+	key, err := cfg.videoKeyTemplate.render(keyValues{
+		UserID:      video.UserID,
+		VideoID:     video.ID,
+		Version:     version,
+		Orientation: renditionAudio,
+		Rendition:   renditionAudio,
+		Ext:         format,
+	})
+	if err != nil {
+		return database.AudioRendition{}, fmt.Errorf("couldn't build storage key: %w", err)
+	}
+	mediaType := media.AudioMediaType(format)
+	err = cfg.putFile(ctx, key, audioPath, mediaType)
+	if err != nil {
+		return database.AudioRendition{}, fmt.Errorf("couldn't upload %s audio: %w", format, err)
+	}
+
+	return database.AudioRendition{
+		VideoID:         video.ID,
+		Format:          format,
+		MediaType:       mediaType,
+		DurationSeconds: audioProbe.Duration(),
+		SizeBytes:       info.Size(),
+		Key:             key,
+		URL:             cfg.objectURL(key),
+	}, nil
+}